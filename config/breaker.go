@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Circuit breaker for backend
+type CircuitBreaker struct {
+	FailureThreshold int `yaml:"failure_threshold"`
+	OpenDuration     int `yaml:"open_duration"`
+	HalfOpenRequests int `yaml:"half_open_requests"`
+}
+
+func (c *CircuitBreaker) FillDefaults() {
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = 5
+	}
+	if c.OpenDuration == 0 {
+		c.OpenDuration = 10000
+	}
+	if c.HalfOpenRequests == 0 {
+		c.HalfOpenRequests = 1
+	}
+}
+
+func (c *CircuitBreaker) Validate() error {
+	if c.FailureThreshold < 1 {
+		return fmt.Errorf("Invalid circuit breaker failure threshold: %d", c.FailureThreshold)
+	}
+	if c.OpenDuration < 1 {
+		return fmt.Errorf("Invalid circuit breaker open duration: %d", c.OpenDuration)
+	}
+	if c.HalfOpenRequests < 1 {
+		return fmt.Errorf("Invalid circuit breaker half open requests: %d", c.HalfOpenRequests)
+	}
+	return nil
+}
+
+func (c *CircuitBreaker) GetKey() string {
+	return fmt.Sprintf("%d %d %d", c.FailureThreshold, c.OpenDuration, c.HalfOpenRequests)
+}
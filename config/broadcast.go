@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Broadcast write (Unit ID 0) forwarding. Devices never respond to
+// broadcast, so gateway answers the client itself.
+type Broadcast struct {
+	Backends []string `yaml:"backends"`
+	FanOut   bool     `yaml:"fan_out"`
+}
+
+func (b *Broadcast) Validate(backendByName map[string]*Backend) error {
+	for _, name := range b.Backends {
+		backend, have := backendByName[name]
+		if !have {
+			return fmt.Errorf("Cannot find backend %s", name)
+		}
+		// Only serial bus supports broadcast
+		if backend.Protocol != "serial" && backend.Protocol != "rtu_over_tcp" {
+			return fmt.Errorf("Broadcast is not available for protocol %s", backend.Protocol)
+		}
+	}
+	return nil
+}
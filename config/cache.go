@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Read response cache TTL for address range, empty field means no restriction
+type CacheRange struct {
+	FunctionCodes []int `yaml:"function_codes"`
+	Address       int   `yaml:"address"`
+	Count         int   `yaml:"count"`
+	TTL           int   `yaml:"ttl"`
+}
+
+func (r *CacheRange) Validate() error {
+	for _, fc := range r.FunctionCodes {
+		if fc < 1 || fc > 4 {
+			return fmt.Errorf("Invalid cache function code: %d", fc)
+		}
+	}
+	if r.Address < 0 || r.Address > 0xffff || r.Count < 0 || r.Address+r.Count > 0x10000 {
+		return fmt.Errorf("Invalid cache address range: %d, %d", r.Address, r.Count)
+	}
+	if r.TTL < 0 {
+		return fmt.Errorf("Invalid cache TTL: %d", r.TTL)
+	}
+	return nil
+}
+
+// Check read request is inside the range
+func (r *CacheRange) Match(funcCode uint8, address int, count int) bool {
+	if len(r.FunctionCodes) > 0 {
+		found := false
+		for _, fc := range r.FunctionCodes {
+			if fc == int(funcCode) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Count == 0 {
+		return true
+	}
+	return address >= r.Address && address+count <= r.Address+r.Count
+}
+
+// Read response cache for unit map, TTL unit is ms and 0 means not cached
+type Cache struct {
+	TTL    int           `yaml:"ttl"`
+	Ranges []*CacheRange `yaml:"ranges"`
+}
+
+func (c *Cache) Validate() error {
+	if c.TTL < 0 {
+		return fmt.Errorf("Invalid cache TTL: %d", c.TTL)
+	}
+	for _, r := range c.Ranges {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns TTL for read request, first matched range is used
+func (c *Cache) GetTTL(funcCode uint8, address int, count int) int {
+	for _, r := range c.Ranges {
+		if r.Match(funcCode, address, count) {
+			return r.TTL
+		}
+	}
+	return c.TTL
+}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 
 	"gopkg.in/yaml.v2"
@@ -14,71 +17,318 @@ var (
 	ErrRequireBackendName    = errors.New("Require backend name field")
 	ErrRequireBackendAddress = errors.New("Require backend address field")
 	ErrInvalidUnitID         = errors.New("Invalid Unit ID")
+	ErrCrossRanges           = errors.New("Address crosses unit map ranges")
 )
 
 type UnitMap struct {
-	UnitID       int    `yaml:"unit_id"`
-	Backend      string `yaml:"backend"`
-	TargetUnitID int    `yaml:"target_unit_id"`
+	// Unit ID, range such as `10-40` or `*` for all Unit IDs
+	UnitID   string   `yaml:"unit_id"`
+	Backend  string   `yaml:"backend"`
+	Backends []string `yaml:"backends"`
+	// Fixed Unit ID or expression such as `unit_id+10`
+	TargetUnitID   string          `yaml:"target_unit_id"`
+	Failback       int             `yaml:"failback"`
+	Cache          *Cache          `yaml:"cache"`
+	Ranges         []*UnitMapRange `yaml:"ranges"`
+	Translate      *Translate      `yaml:"translate"`
+	Aggregate      bool            `yaml:"aggregate"`
+	unitIDs        map[uint8]bool
+	catchAll       bool
+	targetUnitID   int
+	targetRelative bool
 }
 
 func (u *UnitMap) Validate() error {
-	if u.UnitID < 1 || u.UnitID > 255 {
-		return ErrInvalidUnitID
+	if strings.TrimSpace(u.UnitID) == "*" {
+		u.catchAll = true
+	} else {
+		uids, err := parseUnitIDs(u.UnitID)
+		if err != nil {
+			return err
+		}
+		u.unitIDs = uids
+	}
+	if err := u.parseTargetUnitID(); err != nil {
+		return err
+	}
+	// Backends is ordered failover group, first one is primary. Unit map
+	// with ranges may have no default backend, then only addresses in
+	// ranges are served.
+	if len(u.Backends) == 0 {
+		if u.Backend != "" || len(u.Ranges) == 0 {
+			u.Backends = []string{u.Backend}
+		}
+	} else if u.Backend == "" {
+		u.Backend = u.Backends[0]
+	} else if u.Backend != u.Backends[0] {
+		return fmt.Errorf("Unit Map %s backend should be first one of backends", u.UnitID)
 	}
-	if u.TargetUnitID == 0 {
-		u.TargetUnitID = 1
+	if u.Failback < 0 {
+		return fmt.Errorf("Invalid failback: %d", u.Failback)
+	}
+	if u.Cache != nil {
+		if err := u.Cache.Validate(); err != nil {
+			return fmt.Errorf("Unit Map %s: %v", u.UnitID, err)
+		}
+	}
+	if u.Aggregate && len(u.Ranges) == 0 {
+		return fmt.Errorf("Unit Map %s aggregate requires ranges", u.UnitID)
+	}
+	if u.Translate != nil {
+		if err := u.Translate.Validate(); err != nil {
+			return fmt.Errorf("Unit Map %s: %v", u.UnitID, err)
+		}
 	}
-	if u.TargetUnitID < 1 || u.TargetUnitID > 255 {
-		return ErrInvalidUnitID
+	for i, r := range u.Ranges {
+		if err := r.Validate(u); err != nil {
+			return fmt.Errorf("Unit Map %s: %v", u.UnitID, err)
+		}
+		for _, o := range u.Ranges[:i] {
+			if o.Overlaps(r.Table, r.Address, r.Count) {
+				return fmt.Errorf("Unit Map %s got overlapped ranges: %s %d, %d", u.UnitID, r.Table, r.Address, r.Count)
+			}
+		}
 	}
 	return nil
 }
 
+// Parse target Unit ID, it is fixed Unit ID (default 1) or request Unit ID
+// with offset such as `unit_id`, `unit_id+10` or `unit_id-10`
+func (u *UnitMap) parseTargetUnitID() error {
+	val := strings.ReplaceAll(u.TargetUnitID, " ", "")
+	u.targetRelative = strings.HasPrefix(val, "unit_id")
+	var err error
+	switch {
+	case val == "":
+		u.targetUnitID = 1
+	case u.targetRelative:
+		u.targetUnitID = 0
+		if offset := strings.TrimPrefix(val, "unit_id"); offset != "" {
+			if offset[0] != '+' && offset[0] != '-' {
+				return fmt.Errorf("Invalid target Unit ID: %s", u.TargetUnitID)
+			}
+			u.targetUnitID, err = strconv.Atoi(offset)
+		}
+	default:
+		u.targetUnitID, err = strconv.Atoi(val)
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid target Unit ID: %s", u.TargetUnitID)
+	}
+	// Check target Unit IDs of all mapped Unit IDs
+	for uid := 1; uid <= 255; uid++ {
+		if !u.IsCatchAll() && !u.unitIDs[uint8(uid)] {
+			continue
+		}
+		target := u.targetUnitID
+		if u.targetRelative {
+			target += uid
+		}
+		if target < 1 || target > 255 {
+			return fmt.Errorf("Unit Map %s got invalid target Unit ID %d for Unit ID %d", u.UnitID, target, uid)
+		}
+	}
+	return nil
+}
+
+// Unit map for all Unit IDs not configured in other unit maps
+func (u *UnitMap) IsCatchAll() bool {
+	return u.catchAll
+}
+
+func (u *UnitMap) GetTargetUnitID(uid uint8) uint8 {
+	if u.targetRelative {
+		return uint8(int(uid) + u.targetUnitID)
+	}
+	return uint8(u.targetUnitID)
+}
+
+// Returns range contains the address range, if the address range is not
+// inside one range but overlaps with any range returns error.
+func (u *UnitMap) GetRange(table string, address int, count int) (*UnitMapRange, error) {
+	for _, r := range u.Ranges {
+		if r.Contains(table, address, count) {
+			return r, nil
+		}
+		if r.Overlaps(table, address, count) {
+			return nil, ErrCrossRanges
+		}
+	}
+	return nil, nil
+}
+
+// Split address range by unit map ranges, each part is inside one range or
+// not in any range.
+func (u *UnitMap) SplitRange(table string, address int, count int) []AddressRange {
+	var parts []AddressRange
+	end := address + count
+	for address < end {
+		next := end
+		for _, r := range u.Ranges {
+			if r.Table != table {
+				continue
+			}
+			if r.Address <= address && address < r.Address+r.Count {
+				next = min(next, r.Address+r.Count)
+			} else if r.Address > address {
+				next = min(next, r.Address)
+			}
+		}
+		parts = append(parts, AddressRange{table, address, next - address})
+		address = next
+	}
+	return parts
+}
+
+type SerialOptions struct {
+	Baudrate int    `yaml:"baudrate"`
+	Databits int    `yaml:"databits"`
+	Stopbits int    `yaml:"stopbits"`
+	Parity   string `yaml:"parity"`
+	Framing  string `yaml:"framing"`
+}
+
+func (s *SerialOptions) FillDefaults() {
+	if s.Framing == "" {
+		s.Framing = "rtu"
+	}
+	if s.Baudrate == 0 {
+		s.Baudrate = 9600
+	}
+	if s.Databits == 0 {
+		// ASCII mode use 7 data bits by default
+		if s.Framing == "ascii" {
+			s.Databits = 7
+		} else {
+			s.Databits = 8
+		}
+	}
+	if s.Stopbits == 0 {
+		s.Stopbits = 1
+	}
+	if s.Parity == "" {
+		// ASCII mode use even parity by default
+		if s.Framing == "ascii" {
+			s.Parity = "E"
+		} else {
+			s.Parity = "N"
+		}
+	}
+}
+
+func (s *SerialOptions) Validate() error {
+	switch s.Parity {
+	case "N", "E", "O":
+	default:
+		return fmt.Errorf("Invalid parity option: %s", s.Parity)
+	}
+	switch s.Framing {
+	case "rtu", "ascii":
+	default:
+		return fmt.Errorf("Invalid framing option: %s", s.Framing)
+	}
+	return nil
+}
+
+func (s *SerialOptions) GetSerialKey() string {
+	return fmt.Sprintf("%d %d %d %s %s", s.Baudrate, s.Databits, s.Stopbits, s.Parity, s.Framing)
+}
+
 type Backend struct {
-	Name        string `yaml:"name"`
-	Protocol    string `yaml:"protocol"`
-	Address     string `yaml:"address"`
-	Baudrate    int    `yaml:"baudrate"`
-	Databits    int    `yaml:"databits"`
-	Stopbits    int    `yaml:"stopbits"`
-	Parity      string `yaml:"parity"`
-	Timeout     int    `yaml:"timeout"`
-	TlsVerify   bool   `yaml:"tls_verify"`
-	Connections int    `yaml:"connections"`
+	SerialOptions   `yaml:",inline"`
+	Name            string          `yaml:"name"`
+	Protocol        string          `yaml:"protocol"`
+	Address         string          `yaml:"address"`
+	Timeout         int             `yaml:"timeout"`
+	TlsVerify       *bool           `yaml:"tls_verify"`
+	CAFile          string          `yaml:"ca_file"`
+	CertFile        string          `yaml:"cert_file"`
+	KeyFile         string          `yaml:"key_file"`
+	ServerName      string          `yaml:"server_name"`
+	TlsMinVersion   string          `yaml:"tls_min_version"`
+	TlsCiphers      []string        `yaml:"tls_ciphers"`
+	Connections     int             `yaml:"connections"`
+	HealthCheck     *HealthCheck    `yaml:"health_check"`
+	CircuitBreaker  *CircuitBreaker `yaml:"circuit_breaker"`
+	Retry           *RetryPolicy    `yaml:"retry"`
+	Pipeline        bool            `yaml:"pipeline"`
+	MaxInFlight     int             `yaml:"max_in_flight"`
+	QueueSize       int             `yaml:"queue_size"`
+	QueueTimeout    int             `yaml:"queue_timeout"`
+	Scheduler       *Scheduler      `yaml:"scheduler"`
+	Poll            []*Poll         `yaml:"poll"`
+	Optimizer       *Optimizer      `yaml:"optimizer"`
+	TurnaroundDelay int             `yaml:"turnaround_delay"`
+	tlsConfig       *tls.Config
+	tlsKey          string
 }
 
 func (b *Backend) FillDefaults() {
 	if b.Protocol == "serial" {
-		b.fillSerialDefaults()
+		b.SerialOptions.FillDefaults()
 	}
 	if b.Connections == 0 {
 		b.Connections = 1
 	}
-}
-
-func (b *Backend) fillSerialDefaults() {
-	if b.Baudrate == 0 {
-		b.Baudrate = 9600
+	// Verify server's certificate unless it is disabled explicitly
+	if b.Protocol == "tls" && b.TlsVerify == nil {
+		verify := true
+		b.TlsVerify = &verify
+	}
+	if b.Pipeline && b.MaxInFlight == 0 {
+		b.MaxInFlight = 16
+	}
+	if b.HealthCheck != nil {
+		b.HealthCheck.FillDefaults()
 	}
-	if b.Databits == 0 {
-		b.Databits = 8
+	if b.CircuitBreaker != nil {
+		b.CircuitBreaker.FillDefaults()
 	}
-	if b.Stopbits == 0 {
-		b.Stopbits = 1
+	for _, p := range b.Poll {
+		p.FillDefaults()
 	}
-	if b.Parity == "" {
-		b.Parity = "N"
+	if b.Optimizer != nil {
+		b.Optimizer.FillDefaults()
+	}
+	if b.TurnaroundDelay == 0 && (b.Protocol == "serial" || b.Protocol == "rtu_over_tcp") {
+		b.TurnaroundDelay = 100
+	}
+	if b.Retry == nil {
+		b.Retry = defaultRetryPolicy(b.Protocol)
+	} else {
+		b.Retry.FillDefaults()
 	}
 }
 
 func (b *Backend) GetBackendKey() string {
 	base := fmt.Sprintf("%s %s %s %d", b.Name, b.Protocol, b.Address, b.Timeout)
-	if b.Protocol == "tcp" {
-		return base
+	if b.HealthCheck != nil {
+		base += " " + b.HealthCheck.GetKey()
+	}
+	if b.CircuitBreaker != nil {
+		base += " " + b.CircuitBreaker.GetKey()
 	}
-	serialKey := fmt.Sprintf(" %d %d %d %s", b.Baudrate, b.Databits, b.Stopbits, b.Parity)
-	return base + serialKey
+	base += " " + b.Retry.GetKey()
+	if b.Pipeline {
+		base += fmt.Sprintf(" pipeline %d", b.MaxInFlight)
+	}
+	base += fmt.Sprintf(" queue %d %d turnaround %d", b.QueueSize, b.QueueTimeout, b.TurnaroundDelay)
+	if b.Scheduler != nil {
+		base += " scheduler " + b.Scheduler.GetKey()
+	}
+	for _, p := range b.Poll {
+		base += " poll " + p.GetKey()
+	}
+	if b.Optimizer != nil {
+		base += " optimizer " + b.Optimizer.GetKey()
+	}
+	switch b.Protocol {
+	case "serial":
+		return base + " " + b.GetSerialKey()
+	case "tls":
+		return base + " " + b.tlsKey
+	}
+	return base
 }
 
 func (b *Backend) Validate() error {
@@ -89,16 +339,63 @@ func (b *Backend) Validate() error {
 	if b.Address == "" {
 		return ErrRequireBackendAddress
 	}
+	if b.HealthCheck != nil {
+		if err := b.HealthCheck.Validate(); err != nil {
+			return err
+		}
+	}
+	if b.CircuitBreaker != nil {
+		if err := b.CircuitBreaker.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := b.Retry.Validate(); err != nil {
+		return err
+	}
+	if b.Scheduler != nil {
+		if err := b.Scheduler.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, p := range b.Poll {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
 	switch b.Protocol {
-	case "serial", "tcp", "tls":
+	case "serial", "tcp", "tls", "rtu_over_tcp":
 	default:
 		return fmt.Errorf("Invalid protocol %s", b.Protocol)
 	}
+	if b.QueueSize < 0 || b.QueueTimeout < 0 {
+		return fmt.Errorf("Invalid queue size or timeout: %d, %d", b.QueueSize, b.QueueTimeout)
+	}
+	if b.TurnaroundDelay < 0 {
+		return fmt.Errorf("Invalid turnaround delay: %d", b.TurnaroundDelay)
+	}
+	if b.Optimizer != nil {
+		// Optimizer works for serial bus only
+		if b.Protocol != "serial" && b.Protocol != "rtu_over_tcp" {
+			return fmt.Errorf("Optimizer is not available for protocol %s", b.Protocol)
+		}
+		if err := b.Optimizer.Validate(); err != nil {
+			return err
+		}
+	}
+	// Pipeline requires MBAP transaction ID
+	if b.Pipeline && b.Protocol != "tcp" && b.Protocol != "tls" {
+		return fmt.Errorf("Pipeline is not available for protocol %s", b.Protocol)
+	}
 	switch b.Protocol {
-	case "tcp":
+	case "tcp", "rtu_over_tcp":
 		return b.validateTcp()
+	case "tls":
+		if err := b.validateTcp(); err != nil {
+			return err
+		}
+		return b.loadTLSConfig()
 	case "serial":
-		return b.validateSerial()
+		return b.SerialOptions.Validate()
 	}
 	return nil
 }
@@ -111,26 +408,87 @@ func (b *Backend) validateTcp() error {
 	if b.Connections < 1 {
 		return fmt.Errorf("Invalid connections: %d", b.Connections)
 	}
+	if b.Pipeline && (b.MaxInFlight < 1 || b.MaxInFlight > 1024) {
+		return fmt.Errorf("Invalid max in flight: %d", b.MaxInFlight)
+	}
 	return nil
 }
 
-func (b *Backend) validateSerial() error {
-	switch b.Parity {
-	case "N", "E", "O":
-	default:
-		return fmt.Errorf("Invalid parity option: %s", b.Parity)
+type unitMapTable struct {
+	unitIDToBackend map[uint8]*Backend
+	unitIDToUnitMap map[uint8]*UnitMap
+	// Catch all unit map
+	defaultBackend *Backend
+	defaultUnitMap *UnitMap
+}
+
+func newUnitMapTable(umaps []*UnitMap, backendByName map[string]*Backend) (*unitMapTable, error) {
+	ret := &unitMapTable{
+		unitIDToBackend: map[uint8]*Backend{},
+		unitIDToUnitMap: map[uint8]*UnitMap{},
 	}
-	return nil
+	for _, um := range umaps {
+		if err := um.Validate(); err != nil {
+			return nil, err
+		}
+		for _, bname := range um.Backends {
+			if _, have := backendByName[bname]; !have {
+				return nil, fmt.Errorf("Cannot find backend %s", bname)
+			}
+		}
+		for _, r := range um.Ranges {
+			if _, have := backendByName[r.Backend]; !have {
+				return nil, fmt.Errorf("Cannot find backend %s", r.Backend)
+			}
+		}
+		backend := backendByName[um.Backend]
+		if backend == nil && len(um.Ranges) > 0 {
+			backend = backendByName[um.Ranges[0].Backend]
+		}
+		if um.IsCatchAll() {
+			if ret.defaultUnitMap != nil {
+				return nil, fmt.Errorf("Unit Map got duplicate catch all Unit ID")
+			}
+			ret.defaultBackend = backend
+			ret.defaultUnitMap = um
+			continue
+		}
+		for uid := range um.unitIDs {
+			// Check for duplicate or overlapped unit ID
+			if _, have := ret.unitIDToBackend[uid]; have {
+				return nil, fmt.Errorf("Unit Map got duplicate Unit ID: %d", uid)
+			}
+			ret.unitIDToBackend[uid] = backend
+			ret.unitIDToUnitMap[uid] = um
+		}
+	}
+	return ret, nil
+}
+
+func (t *unitMapTable) get(uid uint8) (*UnitMap, *Backend) {
+	umap, uhave := t.unitIDToUnitMap[uid]
+	back, bhave := t.unitIDToBackend[uid]
+	if uhave && bhave {
+		return umap, back
+	}
+	if t.defaultUnitMap != nil {
+		return t.defaultUnitMap, t.defaultBackend
+	}
+	return nil, nil
 }
 
 type Config struct {
-	fname           string
-	lock            sync.RWMutex
-	Backends        []*Backend `yaml:"backends"`
-	UnitMaps        []*UnitMap `yaml:"unit_map"`
-	unitIDToBackend map[uint8]*Backend
-	unitIDToUnitMap map[uint8]*UnitMap
-	backendByName   map[string]*Backend
+	fname          string
+	lock           sync.RWMutex
+	Backends       []*Backend  `yaml:"backends"`
+	UnitMaps       []*UnitMap  `yaml:"unit_map"`
+	Listeners      []*Listener `yaml:"listeners"`
+	Roles          []*Role     `yaml:"roles"`
+	Broadcast      *Broadcast  `yaml:"broadcast"`
+	unitMaps       *unitMapTable
+	backendByName  map[string]*Backend
+	listenerByName map[string]*Listener
+	roleByName     map[string]*Role
 }
 
 func NewConfig(fname string) (*Config, error) {
@@ -166,32 +524,58 @@ func (c *Config) Reload() error {
 		}
 	}
 
-	uidToBackend := map[uint8]*Backend{}
-	uidToUMap := map[uint8]*UnitMap{}
-	for _, um := range nc.UnitMaps {
-		if err = um.Validate(); err != nil {
+	unitMaps, err := newUnitMapTable(nc.UnitMaps, backendByName)
+	if err != nil {
+		return err
+	}
+
+	if nc.Broadcast != nil {
+		if err = nc.Broadcast.Validate(backendByName); err != nil {
+			return err
+		}
+	}
+
+	listenerByName := map[string]*Listener{}
+	for _, l := range nc.Listeners {
+		if err = l.Validate(); err != nil {
 			return err
 		}
-		bname := um.Backend
-		backend, have := backendByName[bname]
-		if !have {
-			return fmt.Errorf("Cannot find backend %s", bname)
+		// Check for duplicate listener name
+		if _, have := listenerByName[l.Name]; have {
+			return fmt.Errorf("Listener name %s is duplicate", l.Name)
+		}
+		listenerByName[l.Name] = l
+		if l.UnitMaps == nil {
+			continue
+		}
+		l.unitMaps, err = newUnitMapTable(l.UnitMaps, backendByName)
+		if err != nil {
+			return fmt.Errorf("Listener %s: %v", l.Name, err)
+		}
+	}
+
+	roleByName := map[string]*Role{}
+	for _, r := range nc.Roles {
+		if err = r.Validate(); err != nil {
+			return err
 		}
-		uid := uint8(um.UnitID)
-		// Check for duplicate unit ID
-		if _, have := uidToBackend[uid]; have {
-			return fmt.Errorf("Unit Map got duplicate Unit ID: %d", uid)
+		// Check for duplicate role name
+		if _, have := roleByName[r.Name]; have {
+			return fmt.Errorf("Role name %s is duplicate", r.Name)
 		}
-		uidToBackend[uid] = backend
-		uidToUMap[uid] = um
+		roleByName[r.Name] = r
 	}
 
 	c.lock.Lock()
 	c.Backends = nc.Backends
 	c.UnitMaps = nc.UnitMaps
-	c.unitIDToBackend = uidToBackend
-	c.unitIDToUnitMap = uidToUMap
+	c.Listeners = nc.Listeners
+	c.unitMaps = unitMaps
 	c.backendByName = backendByName
+	c.Roles = nc.Roles
+	c.listenerByName = listenerByName
+	c.roleByName = roleByName
+	c.Broadcast = nc.Broadcast
 	c.lock.Unlock()
 	return nil
 }
@@ -202,6 +586,19 @@ func (c *Config) GetBackends() []*Backend {
 	return c.Backends
 }
 
+func (c *Config) GetListeners() []*Listener {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.Listeners
+}
+
+// Returns nil if broadcast is not enabled
+func (c *Config) GetBroadcast() *Broadcast {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.Broadcast
+}
+
 func (c *Config) GetBackendByName(name string) *Backend {
 	c.lock.RLock()
 	back, have := c.backendByName[name]
@@ -212,13 +609,47 @@ func (c *Config) GetBackendByName(name string) *Backend {
 	return nil
 }
 
-func (c *Config) GetUnitIDMap(uid uint8) (*UnitMap, *Backend) {
+func (c *Config) GetListenerByName(name string) *Listener {
 	c.lock.RLock()
-	umap, uhave := c.unitIDToUnitMap[uid]
-	back, bhave := c.unitIDToBackend[uid]
+	l, have := c.listenerByName[name]
 	c.lock.RUnlock()
-	if uhave && bhave {
-		return umap, back
+	if have {
+		return l
 	}
-	return nil, nil
+	return nil
+}
+
+func (c *Config) GetRoleByName(name string) *Role {
+	c.lock.RLock()
+	role, have := c.roleByName[name]
+	c.lock.RUnlock()
+	if have {
+		return role
+	}
+	return nil
+}
+
+// Check listener accepts broadcast (Unit ID 0) requests
+func (c *Config) IsBroadcastAllowed(listener string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	l, have := c.listenerByName[listener]
+	return have && l.Broadcast
+}
+
+// Get unit map for the Unit ID received by listener, if listener has its
+// own unit map it will be used instead of global one.
+func (c *Config) GetUnitIDMap(listener string, uid uint8) (*UnitMap, *Backend) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	table := c.unitMaps
+	if l, have := c.listenerByName[listener]; have {
+		if !l.IsUnitIDAllowed(uid) {
+			return nil, nil
+		}
+		if l.unitMaps != nil {
+			table = l.unitMaps
+		}
+	}
+	return table.get(uid)
 }
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Health check read request for backend
+type HealthCheck struct {
+	FunctionCode int `yaml:"function_code"`
+	UnitID       int `yaml:"unit_id"`
+	Address      int `yaml:"address"`
+	Count        int `yaml:"count"`
+	Interval     int `yaml:"interval"`
+}
+
+func (h *HealthCheck) FillDefaults() {
+	if h.FunctionCode == 0 {
+		h.FunctionCode = 3
+	}
+	if h.UnitID == 0 {
+		h.UnitID = 1
+	}
+	if h.Count == 0 {
+		h.Count = 1
+	}
+	if h.Interval == 0 {
+		h.Interval = 5000
+	}
+}
+
+func (h *HealthCheck) Validate() error {
+	switch h.FunctionCode {
+	case 1, 2, 3, 4:
+	default:
+		return fmt.Errorf("Invalid health check function code: %d", h.FunctionCode)
+	}
+	if h.UnitID < 1 || h.UnitID > 255 {
+		return ErrInvalidUnitID
+	}
+	if h.Address < 0 || h.Address > 0xffff || h.Count < 1 || h.Address+h.Count > 0x10000 {
+		return fmt.Errorf("Invalid health check address range: %d, %d", h.Address, h.Count)
+	}
+	if h.Interval < 0 {
+		return fmt.Errorf("Invalid health check interval: %d", h.Interval)
+	}
+	return nil
+}
+
+func (h *HealthCheck) GetKey() string {
+	return fmt.Sprintf("%d %d %d %d %d", h.FunctionCode, h.UnitID, h.Address, h.Count, h.Interval)
+}
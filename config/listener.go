@@ -0,0 +1,179 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrRequireListenerAddress = errors.New("Require listener address field")
+	ErrRequireCertFile        = errors.New("Require cert_file and key_file field")
+	ErrRequireClientCAFile    = errors.New("Require client_ca_file field")
+	ErrAuthorizationNeedTLS   = errors.New("Authorization only available for tls listener")
+)
+
+type Listener struct {
+	SerialOptions     `yaml:",inline"`
+	Name              string     `yaml:"name"`
+	Address           string     `yaml:"address"`
+	Protocol          string     `yaml:"protocol"`
+	UnitIDs           string     `yaml:"unit_ids"`
+	UnitMaps          []*UnitMap `yaml:"unit_map"`
+	CertFile          string     `yaml:"cert_file"`
+	KeyFile           string     `yaml:"key_file"`
+	ClientCAFile      string     `yaml:"client_ca_file"`
+	RequireClientCert bool       `yaml:"require_client_cert"`
+	Authorization     bool       `yaml:"authorization"`
+	Broadcast         bool       `yaml:"broadcast"`
+	unitIDs           map[uint8]bool
+	unitMaps          *unitMapTable
+	tlsConfig         *tls.Config
+	tlsFingerprint    string
+}
+
+func (l *Listener) FillDefaults() {
+	if l.Protocol == "" {
+		l.Protocol = "mbap"
+	}
+	// Modbus/TCP Security use port 802 by convention
+	if l.Protocol == "tls" && l.Address == "" {
+		l.Address = ":802"
+	}
+	if l.Protocol == "rtu-over-tcp" {
+		l.Protocol = "rtu_over_tcp"
+	}
+	if l.Protocol == "serial" {
+		l.SerialOptions.FillDefaults()
+	}
+	if l.Name == "" {
+		l.Name = l.Address
+	}
+}
+
+func (l *Listener) Validate() error {
+	l.FillDefaults()
+	if l.Address == "" {
+		return ErrRequireListenerAddress
+	}
+	if l.Authorization && l.Protocol != "tls" {
+		return ErrAuthorizationNeedTLS
+	}
+	if l.UnitIDs != "" {
+		uids, err := parseUnitIDs(l.UnitIDs)
+		if err != nil {
+			return err
+		}
+		l.unitIDs = uids
+	}
+	switch l.Protocol {
+	case "mbap", "rtu_over_tcp":
+		_, err := net.ResolveTCPAddr("tcp", l.Address)
+		return err
+	case "tls":
+		_, err := net.ResolveTCPAddr("tcp", l.Address)
+		if err != nil {
+			return err
+		}
+		return l.loadTLSConfig()
+	case "serial":
+		return l.SerialOptions.Validate()
+	}
+	return fmt.Errorf("Invalid listener protocol %s", l.Protocol)
+}
+
+func (l *Listener) loadTLSConfig() error {
+	if l.CertFile == "" || l.KeyFile == "" {
+		return ErrRequireCertFile
+	}
+	if l.RequireClientCert && l.ClientCAFile == "" {
+		return ErrRequireClientCAFile
+	}
+	cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+	if err != nil {
+		return err
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if l.ClientCAFile != "" {
+		conf.ClientCAs, err = loadCertPool(l.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		if l.RequireClientCert {
+			conf.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	l.tlsFingerprint, err = filesFingerprint(l.CertFile, l.KeyFile, l.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	l.tlsConfig = conf
+	return nil
+}
+
+// TLS config for `tls` listener
+func (l *Listener) TLSConfig() *tls.Config {
+	return l.tlsConfig
+}
+
+// Listener should be restarted if key is changed
+func (l *Listener) GetListenerKey() string {
+	base := fmt.Sprintf("%s %s", l.Protocol, l.Address)
+	switch l.Protocol {
+	case "serial":
+		return base + " " + l.GetSerialKey()
+	case "tls":
+		return fmt.Sprintf("%s %t %s", base, l.RequireClientCert, l.tlsFingerprint)
+	}
+	return base
+}
+
+func (l *Listener) IsUnitIDAllowed(uid uint8) bool {
+	if l.unitIDs == nil {
+		return true
+	}
+	return l.unitIDs[uid]
+}
+
+// Parse Unit ID list such as `1-10,20,30-40`
+func parseUnitIDs(val string) (map[uint8]bool, error) {
+	ret := map[uint8]bool{}
+	for _, item := range strings.Split(val, ",") {
+		start, end, err := parseUnitIDRange(strings.TrimSpace(item))
+		if err != nil {
+			return nil, err
+		}
+		for uid := start; uid <= end; uid++ {
+			ret[uint8(uid)] = true
+		}
+	}
+	return ret, nil
+}
+
+// Parse Unit ID range such as `10-40` or single Unit ID
+func parseUnitIDRange(val string) (int, int, error) {
+	parts := strings.SplitN(val, "-", 2)
+	start, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid Unit ID range: %s", val)
+	}
+	end := start
+	if len(parts) == 2 {
+		end, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return 0, 0, fmt.Errorf("Invalid Unit ID range: %s", val)
+		}
+	}
+	if start < 1 || end > 255 || start > end {
+		return 0, 0, fmt.Errorf("Invalid Unit ID range: %s", val)
+	}
+	return start, end, nil
+}
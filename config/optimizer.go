@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Read request optimizer for serial bus. Adjacent queued reads of one unit
+// are merged into one read, reads larger than device max quantity are split.
+type Optimizer struct {
+	Merge        bool `yaml:"merge"`
+	MergeGap     int  `yaml:"merge_gap"`
+	MaxRegisters int  `yaml:"max_registers"`
+	MaxBits      int  `yaml:"max_bits"`
+}
+
+func (o *Optimizer) FillDefaults() {
+	if o.MaxRegisters == 0 {
+		o.MaxRegisters = 125
+	}
+	if o.MaxBits == 0 {
+		o.MaxBits = 2000
+	}
+}
+
+func (o *Optimizer) Validate() error {
+	if o.MergeGap < 0 {
+		return fmt.Errorf("Invalid optimizer merge gap: %d", o.MergeGap)
+	}
+	if o.MaxRegisters < 1 || o.MaxRegisters > 125 {
+		return fmt.Errorf("Invalid optimizer max registers: %d", o.MaxRegisters)
+	}
+	if o.MaxBits < 1 || o.MaxBits > 2000 {
+		return fmt.Errorf("Invalid optimizer max bits: %d", o.MaxBits)
+	}
+	return nil
+}
+
+func (o *Optimizer) GetKey() string {
+	return fmt.Sprintf("%v %d %d %d", o.Merge, o.MergeGap, o.MaxRegisters, o.MaxBits)
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Read request polled by gateway in background, client reads inside the
+// range are answered from the polled data.
+type Poll struct {
+	UnitID       int `yaml:"unit_id"`
+	FunctionCode int `yaml:"function_code"`
+	Address      int `yaml:"address"`
+	Count        int `yaml:"count"`
+	Interval     int `yaml:"interval"`
+	MaxAge       int `yaml:"max_age"`
+}
+
+func (p *Poll) FillDefaults() {
+	if p.UnitID == 0 {
+		p.UnitID = 1
+	}
+	if p.FunctionCode == 0 {
+		p.FunctionCode = 3
+	}
+	if p.Interval == 0 {
+		p.Interval = 1000
+	}
+	if p.MaxAge == 0 {
+		p.MaxAge = 3 * p.Interval
+	}
+}
+
+func (p *Poll) Validate() error {
+	maxCount := 125
+	switch p.FunctionCode {
+	case 1, 2:
+		maxCount = 2000
+	case 3, 4:
+	default:
+		return fmt.Errorf("Invalid poll function code: %d", p.FunctionCode)
+	}
+	if p.UnitID < 1 || p.UnitID > 255 {
+		return ErrInvalidUnitID
+	}
+	if p.Address < 0 || p.Count < 1 || p.Count > maxCount || p.Address+p.Count > 0x10000 {
+		return fmt.Errorf("Invalid poll address range: %d, %d", p.Address, p.Count)
+	}
+	if p.Interval < 0 || p.MaxAge < 0 {
+		return fmt.Errorf("Invalid poll interval or max age: %d, %d", p.Interval, p.MaxAge)
+	}
+	return nil
+}
+
+func (p *Poll) GetKey() string {
+	return fmt.Sprintf("%d %d %d %d %d %d", p.UnitID, p.FunctionCode, p.Address, p.Count, p.Interval, p.MaxAge)
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Data table names of address range
+var dataTables = map[string]bool{
+	"coils":             true,
+	"discrete_inputs":   true,
+	"holding_registers": true,
+	"input_registers":   true,
+}
+
+// Address range [address, address + count) in data table
+type AddressRange struct {
+	Table   string `yaml:"table"`
+	Address int    `yaml:"address"`
+	Count   int    `yaml:"count"`
+}
+
+func (r *AddressRange) Validate() error {
+	if !dataTables[r.Table] {
+		return fmt.Errorf("Invalid range table: %s", r.Table)
+	}
+	if r.Address < 0 || r.Count < 1 || r.Address+r.Count > 0x10000 {
+		return fmt.Errorf("Invalid range address: %d, %d", r.Address, r.Count)
+	}
+	return nil
+}
+
+// Check address range [address, address + count) is inside the range
+func (r *AddressRange) Contains(table string, address int, count int) bool {
+	return r.Table == table && address >= r.Address && address+count <= r.Address+r.Count
+}
+
+// Check address range [address, address + count) overlaps with the range
+func (r *AddressRange) Overlaps(table string, address int, count int) bool {
+	return r.Table == table && address < r.Address+r.Count && r.Address < address+count
+}
+
+// Address range of unit map routed to backend, backend address is request
+// address plus offset.
+type UnitMapRange struct {
+	AddressRange `yaml:",inline"`
+	Backend      string `yaml:"backend"`
+	TargetUnitID int    `yaml:"target_unit_id"`
+	Offset       int    `yaml:"offset"`
+	unitMap      *UnitMap
+}
+
+func (r *UnitMapRange) Validate(parent *UnitMap) error {
+	if err := r.AddressRange.Validate(); err != nil {
+		return err
+	}
+	if r.Address+r.Offset < 0 || r.Address+r.Count+r.Offset > 0x10000 {
+		return fmt.Errorf("Invalid range offset: %d", r.Offset)
+	}
+	if r.Backend == "" {
+		return ErrRequireBackendName
+	}
+	// Target Unit ID 0 means use target Unit ID of unit map
+	if r.TargetUnitID < 0 || r.TargetUnitID > 255 {
+		return ErrInvalidUnitID
+	}
+	r.unitMap = &UnitMap{
+		UnitID:   parent.UnitID,
+		Backend:  r.Backend,
+		Backends: []string{r.Backend},
+	}
+	return nil
+}
+
+// Unit map used to send request of this range
+func (r *UnitMapRange) UnitMap() *UnitMap {
+	return r.unitMap
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"fmt"
+)
+
+var retryErrorNames = map[string]bool{
+	"timeout": true,
+	"reset":   true,
+	"eof":     true,
+	"crc":     true,
+	"frame":   true,
+}
+
+// Retry policy for backend requests
+type RetryPolicy struct {
+	MaxAttempts int      `yaml:"max_attempts"`
+	Backoff     int      `yaml:"backoff"`
+	MaxBackoff  int      `yaml:"max_backoff"`
+	Errors      []string `yaml:"errors"`
+	Exceptions  []int    `yaml:"exceptions"`
+	RetryWrites bool     `yaml:"retry_writes"`
+}
+
+// Retry policy when `retry` is not configured: stream transports retry
+// once when connection closed by peer, serial transport never retry.
+func defaultRetryPolicy(protocol string) *RetryPolicy {
+	if protocol == "serial" {
+		return &RetryPolicy{
+			MaxAttempts: 1,
+		}
+	}
+	return &RetryPolicy{
+		MaxAttempts: 2,
+		Errors:      []string{"reset", "eof"},
+		RetryWrites: true,
+	}
+}
+
+func (r *RetryPolicy) FillDefaults() {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 3
+	}
+	if r.Errors == nil {
+		r.Errors = []string{"timeout", "reset", "eof"}
+	}
+}
+
+func (r *RetryPolicy) Validate() error {
+	if r.MaxAttempts < 1 {
+		return fmt.Errorf("Invalid retry max attempts: %d", r.MaxAttempts)
+	}
+	if r.Backoff < 0 || r.MaxBackoff < 0 {
+		return fmt.Errorf("Invalid retry backoff: %d, %d", r.Backoff, r.MaxBackoff)
+	}
+	for _, name := range r.Errors {
+		if !retryErrorNames[name] {
+			return fmt.Errorf("Invalid retry error: %s", name)
+		}
+	}
+	for _, code := range r.Exceptions {
+		if code < 1 || code > 255 {
+			return fmt.Errorf("Invalid retry exception code: %d", code)
+		}
+	}
+	return nil
+}
+
+func (r *RetryPolicy) GetKey() string {
+	return fmt.Sprintf("%d %d %d %v %v %t", r.MaxAttempts, r.Backoff, r.MaxBackoff, r.Errors, r.Exceptions, r.RetryWrites)
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrRequireRoleName = errors.New("Require role name field")
+)
+
+// Authorization rule, empty field means no restriction
+type RoleRule struct {
+	UnitIDs       string `yaml:"unit_ids"`
+	FunctionCodes []int  `yaml:"function_codes"`
+	Address       int    `yaml:"address"`
+	Count         int    `yaml:"count"`
+	unitIDs       map[uint8]bool
+}
+
+func (r *RoleRule) Validate() error {
+	if r.UnitIDs != "" {
+		uids, err := parseUnitIDs(r.UnitIDs)
+		if err != nil {
+			return err
+		}
+		r.unitIDs = uids
+	}
+	for _, fc := range r.FunctionCodes {
+		if fc < 1 || fc > 127 {
+			return fmt.Errorf("Invalid function code: %d", fc)
+		}
+	}
+	if r.Address < 0 || r.Address > 0xffff || r.Count < 0 || r.Address+r.Count > 0x10000 {
+		return fmt.Errorf("Invalid address range: %d, %d", r.Address, r.Count)
+	}
+	return nil
+}
+
+func (r *RoleRule) MatchUnitID(uid uint8) bool {
+	if r.unitIDs == nil {
+		return true
+	}
+	return r.unitIDs[uid]
+}
+
+func (r *RoleRule) MatchFunctionCode(funcCode uint8) bool {
+	if len(r.FunctionCodes) == 0 {
+		return true
+	}
+	for _, fc := range r.FunctionCodes {
+		if fc == int(funcCode) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check address range [address, address + count) is inside the rule
+func (r *RoleRule) MatchAddress(address int, count int) bool {
+	if r.Count == 0 {
+		return true
+	}
+	return address >= r.Address && address+count <= r.Address+r.Count
+}
+
+type Role struct {
+	Name  string      `yaml:"name"`
+	Rules []*RoleRule `yaml:"rules"`
+}
+
+func (r *Role) Validate() error {
+	if r.Name == "" {
+		return ErrRequireRoleName
+	}
+	for _, rule := range r.Rules {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("Role %s: %v", r.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Request scheduler for backend. Write requests are dispatched first, then
+// requests from high priority clients, then other reads. Clients in same
+// priority are served in round robin.
+type Scheduler struct {
+	// IP, CIDR or TLS client identity
+	HighPriorityClients []string `yaml:"high_priority_clients"`
+	nets                []*net.IPNet
+	identities          map[string]bool
+}
+
+func (s *Scheduler) Validate() error {
+	s.nets = nil
+	s.identities = map[string]bool{}
+	for _, client := range s.HighPriorityClients {
+		client = strings.TrimSpace(client)
+		if client == "" {
+			return fmt.Errorf("Invalid high priority client: empty value")
+		}
+		if strings.Contains(client, "/") {
+			_, ipnet, err := net.ParseCIDR(client)
+			if err != nil {
+				return fmt.Errorf("Invalid high priority client %s: %v", client, err)
+			}
+			s.nets = append(s.nets, ipnet)
+			continue
+		}
+		if ip := net.ParseIP(client); ip != nil {
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			s.nets = append(s.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		s.identities[client] = true
+	}
+	return nil
+}
+
+func (s *Scheduler) GetKey() string {
+	return strings.Join(s.HighPriorityClients, ",")
+}
+
+// Check client address or identity is configured as high priority
+func (s *Scheduler) IsHighPriorityClient(ip net.IP, identity string) bool {
+	if identity != "" && s.identities[identity] {
+		return true
+	}
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range s.nets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
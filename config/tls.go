@@ -0,0 +1,125 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+)
+
+// Calculate fingerprint for TLS material files, so changed files can be
+// detected when reload config file.
+func filesFingerprint(files ...string) (string, error) {
+	h := sha256.New()
+	for _, fname := range files {
+		if fname == "" {
+			continue
+		}
+		data, err := os.ReadFile(fname)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(fname))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func loadCertPool(fname string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificate found in %s", fname)
+	}
+	return pool, nil
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func parseTLSCiphers(names []string) ([]uint16, error) {
+	suites := map[string]uint16{}
+	for _, cs := range tls.CipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		suites[cs.Name] = cs.ID
+	}
+	ret := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, have := suites[name]
+		if !have {
+			return nil, fmt.Errorf("Invalid TLS cipher: %s", name)
+		}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
+
+// Build TLS client config for `tls` backend, files are loaded once when
+// config file is loaded.
+func (b *Backend) loadTLSConfig() error {
+	serverName := b.ServerName
+	if serverName == "" {
+		// Transport dials resolved IP, so verify against host of address
+		host, _, err := net.SplitHostPort(b.Address)
+		if err != nil {
+			return err
+		}
+		serverName = host
+	}
+	conf := &tls.Config{
+		InsecureSkipVerify: !*b.TlsVerify,
+		ServerName:         serverName,
+	}
+	if b.CAFile != "" {
+		pool, err := loadCertPool(b.CAFile)
+		if err != nil {
+			return err
+		}
+		conf.RootCAs = pool
+	}
+	if b.CertFile != "" || b.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(b.CertFile, b.KeyFile)
+		if err != nil {
+			return err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	if b.TlsMinVersion != "" {
+		ver, have := tlsVersions[b.TlsMinVersion]
+		if !have {
+			return fmt.Errorf("Invalid TLS version: %s", b.TlsMinVersion)
+		}
+		conf.MinVersion = ver
+	}
+	if len(b.TlsCiphers) > 0 {
+		ciphers, err := parseTLSCiphers(b.TlsCiphers)
+		if err != nil {
+			return err
+		}
+		conf.CipherSuites = ciphers
+	}
+	fingerprint, err := filesFingerprint(b.CAFile, b.CertFile, b.KeyFile)
+	if err != nil {
+		return err
+	}
+	b.tlsKey = fmt.Sprintf("%t %s %s %v %s", *b.TlsVerify, serverName, b.TlsMinVersion, b.TlsCiphers, fingerprint)
+	b.tlsConfig = conf
+	return nil
+}
+
+// TLS config for `tls` backend
+func (b *Backend) TLSConfig() *tls.Config {
+	return b.tlsConfig
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+)
+
+// Remap address range to target table and address. Registers can only be
+// mapped to registers and bits to bits.
+type TranslateRule struct {
+	AddressRange  `yaml:",inline"`
+	TargetTable   string `yaml:"target_table"`
+	TargetAddress int    `yaml:"target_address"`
+}
+
+func (r *TranslateRule) Validate() error {
+	if err := r.AddressRange.Validate(); err != nil {
+		return err
+	}
+	if r.TargetTable == "" {
+		r.TargetTable = r.Table
+	}
+	if !dataTables[r.TargetTable] {
+		return fmt.Errorf("Invalid translate target table: %s", r.TargetTable)
+	}
+	if isRegisterTable(r.Table) != isRegisterTable(r.TargetTable) {
+		return fmt.Errorf("Cannot translate %s to %s", r.Table, r.TargetTable)
+	}
+	if r.TargetAddress < 0 || r.TargetAddress+r.Count > 0x10000 {
+		return fmt.Errorf("Invalid translate target address: %d", r.TargetAddress)
+	}
+	return nil
+}
+
+func isRegisterTable(table string) bool {
+	return table == "holding_registers" || table == "input_registers"
+}
+
+// Address translation of unit map, request address not in any rule is
+// added by offset.
+type Translate struct {
+	Offset int              `yaml:"offset"`
+	Rules  []*TranslateRule `yaml:"rules"`
+}
+
+func (t *Translate) Validate() error {
+	if t.Offset < -0xffff || t.Offset > 0xffff {
+		return fmt.Errorf("Invalid translate offset: %d", t.Offset)
+	}
+	for i, r := range t.Rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		for _, o := range t.Rules[:i] {
+			if o.Overlaps(r.Table, r.Address, r.Count) {
+				return fmt.Errorf("Got overlapped translate rules: %s %d, %d", r.Table, r.Address, r.Count)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns rule contains the address range, if the address range is not
+// inside one rule but overlaps with any rule returns error.
+func (t *Translate) GetRule(table string, address int, count int) (*TranslateRule, error) {
+	for _, r := range t.Rules {
+		if r.Contains(table, address, count) {
+			return r, nil
+		}
+		if r.Overlaps(table, address, count) {
+			return nil, ErrCrossRanges
+		}
+	}
+	return nil, nil
+}
@@ -35,9 +35,9 @@ func main() {
 
 	log.SetOutput(os.Stdout)
 
-	flag.StringVar(&listenAddr, "l", ":502", "Modbus TCP server listen address")
+	flag.StringVar(&listenAddr, "l", ":502", "Modbus TCP server listen address, used when no listeners in config file")
 	flag.StringVar(&configFile, "c", "config.yaml", "Config file name")
-	flag.IntVar(&timeout, "t", 0, "Timeout unit is ms")
+	flag.IntVar(&timeout, "t", 0, "Request timeout unit is ms, expired requests are dropped with gateway exception, 0 means no timeout")
 	flag.BoolVar(&version, "v", false, "Show version")
 	flag.Parse()
 
@@ -58,13 +58,13 @@ func main() {
 	}
 
 	router := server.NewRouter(cfg)
-	server := server.NewTCPServer(listenAddr, timeout, router)
-	err = server.Start()
+	listeners := server.NewListenerManager(cfg, router, listenAddr, timeout)
+	err = listeners.Start()
 	if err != nil {
-		fmt.Println("Cannot start TCP server:", err)
+		fmt.Println("Cannot start server:", err)
+		listeners.Stop()
 		return
 	}
-	fmt.Println("Start Modbus TCP server at", listenAddr)
 	WaitSignal(func() {
 		err := cfg.Reload()
 		if err != nil {
@@ -72,9 +72,10 @@ func main() {
 			return
 		}
 		router.Reload()
+		listeners.Reload()
 	}, func() {
 		router.Stop()
-		server.Stop()
+		listeners.Stop()
 	})
 }
 
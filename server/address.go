@@ -0,0 +1,70 @@
+package server
+
+// Modbus data tables
+const (
+	tableCoils            = 1
+	tableDiscreteInputs   = 2
+	tableHoldingRegisters = 3
+	tableInputRegisters   = 4
+)
+
+// Address range in one data table accessed by request
+type addressRange struct {
+	table   int
+	address int
+	count   int
+}
+
+func (r addressRange) overlaps(o addressRange) bool {
+	return r.table == o.table && r.address < o.address+o.count && o.address < r.address+r.count
+}
+
+// Returns address ranges accessed by request, function codes without data
+// table access (such as file record) returns empty ranges.
+func requestRanges(req *pdu) ([]addressRange, error) {
+	var (
+		table int
+		count int
+	)
+	p := req.payload
+	switch req.funcCode {
+	case FCReadCoils, FCWriteMultipleCoils:
+		table = tableCoils
+	case FCReadDiscreteInputs:
+		table = tableDiscreteInputs
+	case FCReadHoldingRegisters, FCWriteMultipleRegisters:
+		table = tableHoldingRegisters
+	case FCReadInputRegisters:
+		table = tableInputRegisters
+	case FCWriteSingleCoil:
+		table = tableCoils
+		count = 1
+	case FCWriteSingleRegister, FCMaskWriteRegister:
+		table = tableHoldingRegisters
+		count = 1
+	case FCReadFifoQueue:
+		// Only FIFO pointer address is known
+		if len(p) < 2 {
+			return nil, ErrShortFrame
+		}
+		return []addressRange{{tableHoldingRegisters, int(bytesToUint16(BIG_ENDIAN, p[0:2])), 1}}, nil
+	case FCReadWriteMultipleRegisters:
+		if len(p) < 8 {
+			return nil, ErrShortFrame
+		}
+		return []addressRange{
+			{tableHoldingRegisters, int(bytesToUint16(BIG_ENDIAN, p[0:2])), int(bytesToUint16(BIG_ENDIAN, p[2:4]))},
+			{tableHoldingRegisters, int(bytesToUint16(BIG_ENDIAN, p[4:6])), int(bytesToUint16(BIG_ENDIAN, p[6:8]))},
+		}, nil
+	default:
+		return nil, nil
+	}
+	if len(p) < 4 {
+		return nil, ErrShortFrame
+	}
+	address := int(bytesToUint16(BIG_ENDIAN, p[0:2]))
+	if count == 0 {
+		count = int(bytesToUint16(BIG_ENDIAN, p[2:4]))
+	}
+	return []addressRange{{table, address, count}}, nil
+}
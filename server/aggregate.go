@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Split read across unit map ranges, send parts concurrently and assemble
+// one response. If any part fails the read gets gateway exception.
+func (r *Router) requestAggregate(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu, rng addressRange) (*pdu, error) {
+	// Parts are valid reads even if the whole read is not, so check the
+	// request before split
+	limit := maxReadRegisters
+	if isBitFunction(req.funcCode) {
+		limit = maxReadBits
+	}
+	if rng.count < 1 || rng.count > limit {
+		return modbusErrorPdu(req, MErrIllegalDataValue), nil
+	}
+	if rng.address+rng.count > 0x10000 {
+		return modbusErrorPdu(req, MErrIllegalDataAddress), nil
+	}
+	parts := umap.SplitRange(tableNames[rng.table], rng.address, rng.count)
+	for _, part := range parts {
+		route, _ := umap.GetRange(part.Table, part.Address, part.Count)
+		if route == nil && len(umap.Backends) == 0 {
+			return modbusErrorPdu(req, MErrIllegalDataAddress), nil
+		}
+	}
+
+	var (
+		wg     sync.WaitGroup
+		values = make([][]byte, len(parts))
+		errs   = make([]error, len(parts))
+		codes  = make([]uint8, len(parts))
+	)
+	for i, part := range parts {
+		wg.Add(1)
+		go func(i int, part config.AddressRange) {
+			defer wg.Done()
+			sub := newReadRequest(req.unitID, req.funcCode, part.Address, part.Count)
+			resp, err := r.requestRange(ctx, client, umap, sub)
+			val, ok := readResponseValues(sub, resp, part.Count)
+			if ok {
+				values[i] = val
+				return
+			}
+			errs[i] = err
+			codes[i] = MErrGWPathUnavailable
+			if isGatewayError(resp) {
+				codes[i] = exceptionCode(resp)
+			}
+		}(i, part)
+	}
+	wg.Wait()
+
+	var data []byte
+	for i := range parts {
+		if values[i] == nil {
+			return modbusErrorPdu(req, codes[i]), errs[i]
+		}
+		data = append(data, values[i]...)
+	}
+	return newReadResponse(req, data), nil
+}
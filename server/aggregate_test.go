@@ -0,0 +1,40 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+func TestRequestAggregateInvalid(t *testing.T) {
+	umap := &config.UnitMap{
+		Aggregate: true,
+		Ranges: []*config.UnitMapRange{
+			{AddressRange: config.AddressRange{Table: "holding_registers", Address: 0, Count: 100}, Backend: "A"},
+			{AddressRange: config.AddressRange{Table: "holding_registers", Address: 100, Count: 100}, Backend: "B"},
+			{AddressRange: config.AddressRange{Table: "holding_registers", Address: 65400, Count: 100}, Backend: "A"},
+			{AddressRange: config.AddressRange{Table: "holding_registers", Address: 65500, Count: 36}, Backend: "B"},
+			{AddressRange: config.AddressRange{Table: "coils", Address: 0, Count: 1000}, Backend: "A"},
+			{AddressRange: config.AddressRange{Table: "coils", Address: 1000, Count: 2000}, Backend: "B"},
+		},
+	}
+	tests := []struct {
+		name string
+		req  *pdu
+		code uint8
+	}{
+		// Both parts are valid, but response would be 400 bytes
+		{"too many registers", newReadRequest(1, FCReadHoldingRegisters, 0, 200), MErrIllegalDataValue},
+		{"too many bits", newReadRequest(1, FCReadCoils, 0, 2001), MErrIllegalDataValue},
+		// Last part would wrap to address 0
+		{"address overflow", newReadRequest(1, FCReadHoldingRegisters, 65450, 100), MErrIllegalDataAddress},
+	}
+	r := &Router{}
+	for _, tt := range tests {
+		resp, err := r.requestRange(context.Background(), &clientInfo{}, umap, tt.req)
+		if want := modbusErrorPdu(tt.req, tt.code); err != nil || resp == nil || !equalPdu(resp, want) {
+			t.Errorf("%s: got %+v %v, want %+v", tt.name, resp, err, want)
+		}
+	}
+}
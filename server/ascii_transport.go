@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/hex"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+const (
+	// ':' + 2 * (252 bytes PDU + Unit ID + LRC) + CRLF
+	maxASCIIFrameLength = 513
+)
+
+// Modbus ASCII transport for serial line, it shares connection
+// management with RTU serial transport.
+type asciiTransport struct {
+	*serialTransport
+}
+
+func newAsciiTransport(cfg *config.Backend) *asciiTransport {
+	return &asciiTransport{
+		serialTransport: newSerialTransport(cfg),
+	}
+}
+
+func (at *asciiTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	if err := at.ensureConn(); err != nil {
+		log.Println("Connect backend got error:", err)
+		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
+	}
+	return at.executeRequestASCII(req)
+}
+
+// it will always return pdu response
+func (at *asciiTransport) executeRequestASCII(req *pdu) (*pdu, error) {
+	_, err := at.conn.Write(encodeASCIIFrame(req))
+	if err != nil {
+		return modbusErrorPdu(req, MErrGWPathUnavailable), err
+	}
+
+	// no response for broadcast, let devices process it
+	if req.unitID == 0 {
+		time.Sleep(at.turnaround)
+		return broadcastResponse(req), nil
+	}
+
+	resp, err := readASCIIFrame(at.conn)
+	if err == ErrBadLRC || err == ErrProtocolError || err == ErrShortFrame {
+		// flush any data coming off the link to allow
+		// devices to re-sync
+		time.Sleep(time.Duration(maxASCIIFrameLength) * at.t1)
+		discard(at.conn)
+	}
+
+	if err != nil {
+		return modbusErrorPdu(req, MErrGWPathUnavailable), err
+	}
+	return resp, err
+}
+
+// Encode PDU to ASCII ADU with LRC and CRLF.
+func encodeASCIIFrame(req *pdu) []byte {
+	var (
+		lrc lrc
+		adu []byte
+	)
+	adu = append(adu, req.unitID)
+	adu = append(adu, req.funcCode)
+	adu = append(adu, req.payload...)
+	// calculate lrc
+	lrc.init()
+	lrc.add(adu)
+	adu = append(adu, lrc.value())
+
+	frame := ":" + strings.ToUpper(hex.EncodeToString(adu)) + "\r\n"
+	return []byte(frame)
+}
+
+// Decode hex encoded body (without ':' and CRLF) and check LRC.
+func decodeASCIIFrame(body []byte) (*pdu, error) {
+	adu := make([]byte, hex.DecodedLen(len(body)))
+	_, err := hex.Decode(adu, body)
+	if err != nil {
+		return nil, ErrProtocolError
+	}
+	// Unit ID, function code and LRC
+	if len(adu) < 3 {
+		return nil, ErrShortFrame
+	}
+	var lrc lrc
+	lrc.init()
+	lrc.add(adu[0 : len(adu)-1])
+	if !lrc.isEqual(adu[len(adu)-1]) {
+		return nil, ErrBadLRC
+	}
+	ret := &pdu{
+		unitID:   adu[0],
+		funcCode: adu[1],
+		payload:  adu[2 : len(adu)-1],
+	}
+	return ret, nil
+}
+
+// Read one ASCII frame from the reader, bytes before ':' will be dropped.
+func readASCIIFrame(r io.Reader) (*pdu, error) {
+	var (
+		started bool
+		body    []byte
+	)
+	b := make([]byte, 1)
+	for {
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case b[0] == ':':
+			// Start of frame, drop any partial frame
+			started = true
+			body = body[:0]
+		case !started:
+			continue
+		case b[0] == '\n':
+			if len(body) == 0 || body[len(body)-1] != '\r' {
+				return nil, ErrProtocolError
+			}
+			return decodeASCIIFrame(body[0 : len(body)-1])
+		default:
+			body = append(body, b[0])
+			if len(body) > maxASCIIFrameLength {
+				return nil, ErrProtocolError
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLRC(t *testing.T) {
+	tests := []struct {
+		in  []byte
+		lrc byte
+	}{
+		{[]byte{}, 0x00},
+		{[]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01}, 0xfb},
+		{[]byte{0x11, 0x03, 0x00, 0x6b, 0x00, 0x03}, 0x7e},
+		{[]byte{0xff, 0x01}, 0x00},
+		{[]byte{0x80}, 0x80},
+	}
+	for _, tt := range tests {
+		var l lrc
+		l.init()
+		l.add(tt.in)
+		if got := l.value(); got != tt.lrc {
+			t.Errorf("LRC of % x = 0x%02x, want 0x%02x", tt.in, got, tt.lrc)
+		}
+		if !l.isEqual(tt.lrc) {
+			t.Errorf("LRC of % x is not equal to 0x%02x", tt.in, tt.lrc)
+		}
+	}
+}
+
+func TestEncodeASCIIFrame(t *testing.T) {
+	tests := []struct {
+		req   *pdu
+		frame string
+	}{
+		{&pdu{unitID: 1, funcCode: 3, payload: []byte{0x00, 0x00, 0x00, 0x01}}, ":010300000001FB\r\n"},
+		{&pdu{unitID: 0x11, funcCode: 3, payload: []byte{0x00, 0x6b, 0x00, 0x03}}, ":1103006B00037E\r\n"},
+		{&pdu{unitID: 0xf7, funcCode: 0x83, payload: []byte{0x02}}, ":F7830284\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(encodeASCIIFrame(tt.req)); got != tt.frame {
+			t.Errorf("encodeASCIIFrame(%+v) = %q, want %q", tt.req, got, tt.frame)
+		}
+	}
+}
+
+func TestDecodeASCIIFrame(t *testing.T) {
+	tests := []struct {
+		body string
+		resp *pdu
+		err  error
+	}{
+		{"010300000001FB", &pdu{unitID: 1, funcCode: 3, payload: []byte{0x00, 0x00, 0x00, 0x01}}, nil},
+		{"1103006b00037e", &pdu{unitID: 0x11, funcCode: 3, payload: []byte{0x00, 0x6b, 0x00, 0x03}}, nil},
+		{"0103FC", &pdu{unitID: 1, funcCode: 3, payload: []byte{}}, nil},
+		{"010300000001FA", nil, ErrBadLRC},
+		{"0103", nil, ErrShortFrame},
+		{"0103XX", nil, ErrProtocolError},
+		{"010", nil, ErrProtocolError},
+	}
+	for _, tt := range tests {
+		resp, err := decodeASCIIFrame([]byte(tt.body))
+		if err != tt.err {
+			t.Errorf("decodeASCIIFrame(%q) got error %v, want %v", tt.body, err, tt.err)
+			continue
+		}
+		if tt.resp != nil && !equalPdu(resp, tt.resp) {
+			t.Errorf("decodeASCIIFrame(%q) = %+v, want %+v", tt.body, resp, tt.resp)
+		}
+	}
+}
+
+func TestReadASCIIFrame(t *testing.T) {
+	valid := &pdu{unitID: 1, funcCode: 3, payload: []byte{0x00, 0x00, 0x00, 0x01}}
+	tests := []struct {
+		name  string
+		input string
+		resp  *pdu
+		err   error
+	}{
+		{"frame", ":010300000001FB\r\n", valid, nil},
+		{"leading garbage", "\x00ab\r\n:010300000001FB\r\n", valid, nil},
+		{"restart on colon", ":0103:010300000001FB\r\n", valid, nil},
+		{"missing CR", ":010300000001FB\n", nil, ErrProtocolError},
+		{"empty frame", ":\r\n", nil, ErrShortFrame},
+		{"too long", ":" + strings.Repeat("00", maxASCIIFrameLength) + "\r\n", nil, ErrProtocolError},
+		{"truncated", ":010300", nil, io.EOF},
+	}
+	for _, tt := range tests {
+		resp, err := readASCIIFrame(strings.NewReader(tt.input))
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if tt.resp != nil && !equalPdu(resp, tt.resp) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, resp, tt.resp)
+		}
+	}
+}
+
+func TestASCIIFrameRoundTrip(t *testing.T) {
+	reqs := []*pdu{
+		{unitID: 1, funcCode: FCReadHoldingRegisters, payload: []byte{0x00, 0x10, 0x00, 0x7d}},
+		{unitID: 247, funcCode: FCWriteMultipleRegisters, payload: []byte{0x00, 0x01, 0x00, 0x02, 0x04, 0xde, 0xad, 0xbe, 0xef}},
+		{unitID: 9, funcCode: FCReportServerID, payload: []byte{}},
+	}
+	for _, req := range reqs {
+		frame := encodeASCIIFrame(req)
+		resp, err := readASCIIFrame(bytes.NewReader(frame))
+		if err != nil {
+			t.Errorf("read %q got error: %v", frame, err)
+			continue
+		}
+		if !equalPdu(resp, req) {
+			t.Errorf("read %q = %+v, want %+v", frame, resp, req)
+		}
+	}
+}
+
+func equalPdu(a *pdu, b *pdu) bool {
+	return a.unitID == b.unitID && a.funcCode == b.funcCode && bytes.Equal(a.payload, b.payload)
+}
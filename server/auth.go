@@ -0,0 +1,70 @@
+package server
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"log"
+)
+
+var (
+	// Modbus/TCP Security role extension
+	oidModbusRole = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 50316, 802, 1}
+)
+
+// Extract role from Modbus/TCP Security role extension
+func certificateRole(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidModbusRole) {
+			continue
+		}
+		var role string
+		_, err := asn1.Unmarshal(ext.Value, &role)
+		if err != nil {
+			log.Printf("Parse role extension of %s got error: %v", cert.Subject.CommonName, err)
+			return ""
+		}
+		return role
+	}
+	return ""
+}
+
+// Check the request is allowed by role policy, returns true if listener
+// do not enable authorization.
+func (r *Router) authorize(client *clientInfo, uid uint8, req *pdu) bool {
+	lcfg := r.cfg.GetListenerByName(client.listener)
+	if lcfg == nil || !lcfg.Authorization {
+		return true
+	}
+	if client.role == "" {
+		return false
+	}
+	role := r.cfg.GetRoleByName(client.role)
+	if role == nil {
+		return false
+	}
+	ranges, err := requestRanges(req)
+	if err != nil {
+		return false
+	}
+	for _, rule := range role.Rules {
+		if !rule.MatchUnitID(uid) || !rule.MatchFunctionCode(req.funcCode) {
+			continue
+		}
+		// Request without data table address, such as diagnostics or file
+		// record, is not inside address restricted rule
+		if len(ranges) == 0 && rule.Count > 0 {
+			continue
+		}
+		matched := true
+		for _, ar := range ranges {
+			if !rule.MatchAddress(ar.address, ar.count) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
@@ -1,14 +1,19 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"log"
+	"sync/atomic"
+	"time"
 
 	"github.com/blacktear23/modbus_gateway/config"
 )
 
 var (
 	ErrClientClosed = errors.New("Client closed")
+	ErrQueueFull    = errors.New("Queue is full")
+	ErrQueueTimeout = errors.New("Queue wait timeout")
 )
 
 type Transport interface {
@@ -16,9 +21,43 @@ type Transport interface {
 	Close() error
 }
 
+// State of queued request, worker dispatches request to backend and client
+// abandons it when deadline is exceeded, whichever comes first.
+const (
+	requestQueued int32 = iota
+	requestDispatched
+	requestAbandoned
+)
+
 type modbusRequest struct {
-	req    *pdu
-	respCh chan *modbusResponse
+	ctx      context.Context
+	client   *clientInfo
+	req      *pdu
+	respCh   chan *modbusResponse
+	queuedAt time.Time
+	state    int32
+}
+
+// Mark request is sent to backend, returns false if client abandoned it
+func (r *modbusRequest) dispatch() bool {
+	return atomic.CompareAndSwapInt32(&r.state, requestQueued, requestDispatched)
+}
+
+// Mark request is abandoned by client, returns false if it is already
+// sent to backend
+func (r *modbusRequest) abandon() bool {
+	return atomic.CompareAndSwapInt32(&r.state, requestQueued, requestAbandoned)
+}
+
+func (r *modbusRequest) isDispatched() bool {
+	return atomic.LoadInt32(&r.state) == requestDispatched
+}
+
+func (r *modbusRequest) reply(resp *pdu, err error) {
+	r.respCh <- &modbusResponse{
+		resp: resp,
+		err:  err,
+	}
 }
 
 type modbusResponse struct {
@@ -27,21 +66,44 @@ type modbusResponse struct {
 }
 
 type Backend struct {
-	Name    string
-	bcfg    *config.Backend
-	trans   []Transport
-	ch      chan *modbusRequest
-	running bool
+	Name      string
+	bcfg      *config.Backend
+	trans     []Transport
+	sched     *scheduler
+	running   bool
+	unhealthy int32
+	stopCh    chan struct{}
+	breaker   *circuitBreaker
+	flights   *flightGroup
+	shadows   []*shadowTable
+	// Requests rejected by full queue or queue timeout
+	rejected      uint64
+	lastRejectLog int64
 }
 
 func NewBackend(cfg *config.Backend) *Backend {
 	transports := newTransports(cfg)
-	return &Backend{
-		Name:  cfg.Name,
-		bcfg:  cfg,
-		trans: transports,
-		ch:    make(chan *modbusRequest, len(transports)),
+	queueSize := len(transports)
+	if cfg.QueueSize > 0 {
+		queueSize = cfg.QueueSize
+	} else if cfg.Optimizer != nil && cfg.Optimizer.Merge {
+		queueSize = max(queueSize, defaultMergeQueueSize)
+	}
+	ret := &Backend{
+		Name:    cfg.Name,
+		bcfg:    cfg,
+		trans:   transports,
+		sched:   newScheduler(cfg.Scheduler, queueSize),
+		flights: newFlightGroup(),
+		stopCh:  make(chan struct{}),
 	}
+	if cfg.CircuitBreaker != nil {
+		ret.breaker = newCircuitBreaker(cfg.Name, cfg.CircuitBreaker)
+	}
+	for _, p := range cfg.Poll {
+		ret.shadows = append(ret.shadows, newShadowTable(p))
+	}
+	return ret
 }
 
 func (b *Backend) GetBackendKey() string {
@@ -51,7 +113,8 @@ func (b *Backend) GetBackendKey() string {
 func (b *Backend) Stop() error {
 	var err error
 	b.running = false
-	close(b.ch)
+	close(b.stopCh)
+	b.sched.close()
 	for i, trans := range b.trans {
 		ierr := trans.Close()
 		if ierr != nil {
@@ -65,20 +128,70 @@ func (b *Backend) Stop() error {
 
 func (b *Backend) Start() {
 	b.running = true
+	// Pipelined transport can handle concurrent requests
+	workers := 1
+	if b.bcfg.Pipeline {
+		workers = b.bcfg.MaxInFlight
+	}
 	for i, _ := range b.trans {
-		go b.start(i)
+		for j := 0; j < workers; j++ {
+			go b.start(i)
+		}
+	}
+	if b.bcfg.HealthCheck != nil {
+		go b.runHealthCheck()
+	}
+	for _, st := range b.shadows {
+		go b.runPoll(st)
 	}
 }
 
 func (b *Backend) start(idx int) {
 	log.Printf("Start running backend transport %s [%d]", b.Name, idx)
-	for req := range b.ch {
-		respPdu, err := b.trans[idx].ExecuteRequest(req.req)
-		mresp := &modbusResponse{
-			resp: respPdu,
-			err:  err,
+	queueTimeout := time.Duration(b.bcfg.QueueTimeout) * time.Millisecond
+	for {
+		req, ok := b.sched.next()
+		if !ok {
+			return
 		}
-		req.respCh <- mresp
+		// Request waits too long in queue, do not send it to backend
+		if queueTimeout > 0 && time.Since(req.queuedAt) > queueTimeout {
+			req.reply(nil, ErrQueueTimeout)
+			continue
+		}
+		// Client deadline is exceeded, do not send it to backend
+		if req.ctx.Err() != nil {
+			req.reply(nil, req.ctx.Err())
+			continue
+		}
+		// Client is gone, nobody waits for the response
+		if !req.dispatch() {
+			continue
+		}
+		if b.bcfg.Optimizer != nil {
+			b.executeOptimized(b.trans[idx], req)
+			continue
+		}
+		req.reply(b.executeWithRetry(req.ctx, b.trans[idx], req.req))
+	}
+}
+
+// Check request is expired by queue timeout or client deadline
+func (b *Backend) isExpired(req *modbusRequest) bool {
+	queueTimeout := time.Duration(b.bcfg.QueueTimeout) * time.Millisecond
+	if queueTimeout > 0 && time.Since(req.queuedAt) > queueTimeout {
+		return true
+	}
+	return req.ctx.Err() != nil
+}
+
+func (b *Backend) reject(reason error) {
+	total := atomic.AddUint64(&b.rejected, 1)
+	// Log at most once per second
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&b.lastRejectLog)
+	if now != last && atomic.CompareAndSwapInt64(&b.lastRejectLog, last, now) {
+		log.Printf("Backend %s reject request: %v, total rejected: %d", b.Name, reason, total)
 	}
 }
 
@@ -89,33 +202,107 @@ func (b *Backend) safeSend(req *modbusRequest) (err error) {
 		}
 	}()
 
-	b.ch <- req
-	return
+	// Queue size is configured, reject request immediately when queue is full.
+	// Otherwise wait for queue no longer than queue timeout.
+	nowait := b.bcfg.QueueSize > 0
+	timeout := time.Duration(b.bcfg.QueueTimeout) * time.Millisecond
+	return b.sched.push(req, nowait, timeout)
+}
+
+func (b *Backend) ExecuteRequest(ctx context.Context, client *clientInfo, req *pdu) (*pdu, error) {
+	if !isReadFunction(req.funcCode) {
+		resp, err := b.executeBreaker(ctx, client, req)
+		if isWriteFunction(req.funcCode) {
+			b.invalidateShadow(req)
+		}
+		return resp, err
+	}
+	// Reads inside polled ranges are answered without touching the bus
+	if resp := b.readShadow(req); resp != nil {
+		return resp, nil
+	}
+	// Concurrent identical reads share one backend round trip
+	key := flightKey(b.sched.clientPriority(client), req)
+	resp, err := b.flights.do(ctx, key, func(fctx context.Context) (*pdu, error) {
+		return b.executeBreaker(fctx, client, req)
+	})
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
+	}
+	return resp, err
 }
 
-func (b *Backend) ExecuteRequest(req *pdu) (*pdu, error) {
+// Execute request through circuit breaker
+func (b *Backend) executeBreaker(ctx context.Context, client *clientInfo, req *pdu) (*pdu, error) {
+	if b.breaker == nil {
+		return b.execute(ctx, client, req)
+	}
+	// Fail fast when circuit breaker is open
+	if !b.breaker.allow() {
+		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
+	}
+	resp, sent, err := b.send(ctx, client, req)
+	// Only record outcome of request sent to backend, not queue rejection
+	// or request expired in queue. Client deadline exceeded after request
+	// is sent counts as failure.
+	if sent {
+		b.breaker.record(err == nil && !isGatewayError(resp))
+	} else {
+		b.breaker.release()
+	}
+	return b.sendResult(req, resp, err)
+}
+
+// Send request to backend transports through queue
+func (b *Backend) execute(ctx context.Context, client *clientInfo, req *pdu) (*pdu, error) {
+	resp, _, err := b.send(ctx, client, req)
+	return b.sendResult(req, resp, err)
+}
+
+// Returns exception response for queue rejection and client deadline
+func (b *Backend) sendResult(req *pdu, resp *pdu, err error) (*pdu, error) {
+	switch err {
+	case ErrQueueFull, ErrQueueTimeout:
+		b.reject(err)
+		return modbusErrorPdu(req, MErrServerDeviceBusy), nil
+	case context.DeadlineExceeded, context.Canceled:
+		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
+	}
+	return resp, err
+}
+
+// Send request through queue and wait for response, returns queue or
+// context error if request is not answered by backend. The bool result
+// reports whether request is sent to backend.
+func (b *Backend) send(ctx context.Context, client *clientInfo, req *pdu) (*pdu, bool, error) {
 	if !b.running {
-		return nil, errors.New("Backend not running")
+		return nil, false, errors.New("Backend not running")
+	}
+	if ctx.Err() != nil {
+		return nil, false, ctx.Err()
 	}
 
+	// Worker may send response after client returned, so channel
+	// is buffered and never closed
 	respCh := make(chan *modbusResponse, 1)
-	defer func(ch chan *modbusResponse) {
-		close(ch)
-	}(respCh)
-
 	mreq := &modbusRequest{
-		req:    req,
-		respCh: respCh,
+		ctx:      ctx,
+		client:   client,
+		req:      req,
+		respCh:   respCh,
+		queuedAt: time.Now(),
 	}
 
-	err := b.safeSend(mreq)
-	if err != nil {
-		return nil, err
+	if err := b.safeSend(mreq); err != nil {
+		return nil, false, err
 	}
 
-	resp, ok := <-respCh
-	if !ok {
-		return nil, ErrClientClosed
+	select {
+	case resp := <-respCh:
+		return resp.resp, mreq.isDispatched(), resp.err
+	case <-ctx.Done():
+		// Request may be on the worker already, then backend did not
+		// answer in time
+		return nil, !mreq.abandon(), ctx.Err()
 	}
-	return resp.resp, resp.err
 }
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	name         string
+	threshold    int
+	openDuration time.Duration
+	halfOpenMax  int
+	state        int
+	failures     int
+	probes       int
+	openedAt     time.Time
+	lock         sync.Mutex
+}
+
+func newCircuitBreaker(name string, cfg *config.CircuitBreaker) *circuitBreaker {
+	return &circuitBreaker{
+		name:         name,
+		threshold:    cfg.FailureThreshold,
+		openDuration: time.Duration(cfg.OpenDuration) * time.Millisecond,
+		halfOpenMax:  cfg.HalfOpenRequests,
+		state:        breakerClosed,
+	}
+}
+
+// Check request can be sent to backend
+func (cb *circuitBreaker) allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	switch cb.state {
+	case breakerClosed:
+		return true
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.openDuration {
+			return false
+		}
+		log.Printf("Circuit breaker of backend %s is half open", cb.name)
+		cb.state = breakerHalfOpen
+		cb.probes = 0
+	}
+	// Half open, only limited requests can probe backend
+	if cb.probes >= cb.halfOpenMax {
+		return false
+	}
+	cb.probes++
+	return true
+}
+
+// Record result of the request allowed by breaker
+func (cb *circuitBreaker) record(success bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	switch cb.state {
+	case breakerClosed:
+		if success {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.threshold {
+			cb.open()
+		}
+	case breakerHalfOpen:
+		if success {
+			log.Printf("Circuit breaker of backend %s is closed", cb.name)
+			cb.state = breakerClosed
+			cb.failures = 0
+			return
+		}
+		cb.open()
+	}
+}
+
+// Request allowed by breaker is not sent to backend, so another request
+// can probe backend in half open state
+func (cb *circuitBreaker) release() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if cb.state == breakerHalfOpen && cb.probes > 0 {
+		cb.probes--
+	}
+}
+
+func (cb *circuitBreaker) open() {
+	log.Printf("Circuit breaker of backend %s is open", cb.name)
+	cb.state = breakerOpen
+	cb.openedAt = time.Now()
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Transport answers write requests after delay
+type slowTransport struct {
+	delay time.Duration
+}
+
+func (s *slowTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	time.Sleep(s.delay)
+	return broadcastResponse(req), nil
+}
+
+func (s *slowTransport) Close() error {
+	return nil
+}
+
+func newBreakerBackend(delay time.Duration) *Backend {
+	cfg := &config.Backend{
+		Name:           "test",
+		Retry:          &config.RetryPolicy{MaxAttempts: 1},
+		QueueSize:      4,
+		CircuitBreaker: &config.CircuitBreaker{FailureThreshold: 2, OpenDuration: 1000, HalfOpenRequests: 1},
+	}
+	b := &Backend{
+		Name:    cfg.Name,
+		bcfg:    cfg,
+		trans:   []Transport{&slowTransport{delay: delay}},
+		sched:   newScheduler(nil, cfg.QueueSize),
+		flights: newFlightGroup(),
+		stopCh:  make(chan struct{}),
+		breaker: newCircuitBreaker(cfg.Name, cfg.CircuitBreaker),
+	}
+	b.Start()
+	return b
+}
+
+func writeWithTimeout(b *Backend, timeout time.Duration) *pdu {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	resp, _ := b.ExecuteRequest(ctx, &clientInfo{}, &pdu{unitID: 1, funcCode: FCWriteSingleRegister, payload: []byte{0x00, 0x01, 0x00, 0x01}})
+	return resp
+}
+
+func breakerState(cb *circuitBreaker) (int, int) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	return cb.state, cb.failures
+}
+
+func TestBreakerRecordsAbandonedRequest(t *testing.T) {
+	b := newBreakerBackend(100 * time.Millisecond)
+	defer b.Stop()
+	// Client deadline is shorter than backend answer, request is already
+	// sent so it is a failure
+	for i := 0; i < 2; i++ {
+		resp := writeWithTimeout(b, 20*time.Millisecond)
+		if want := []byte{MErrGWTargetFailedToRespond}; resp == nil || resp.funcCode != 0x80|FCWriteSingleRegister || string(resp.payload) != string(want) {
+			t.Fatalf("got response %+v, want target failed to respond", resp)
+		}
+		// Wait for worker to finish the request
+		time.Sleep(120 * time.Millisecond)
+	}
+	if state, _ := breakerState(b.breaker); state != breakerOpen {
+		t.Errorf("got breaker state %d, want open", state)
+	}
+}
+
+func TestBreakerSkipsExpiredInQueue(t *testing.T) {
+	b := newBreakerBackend(200 * time.Millisecond)
+	defer b.Stop()
+	done := make(chan *pdu)
+	go func() {
+		done <- writeWithTimeout(b, time.Second)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// Worker is busy, requests expire in queue and are never sent
+	for i := 0; i < 2; i++ {
+		writeWithTimeout(b, 20*time.Millisecond)
+	}
+	if state, failures := breakerState(b.breaker); state != breakerClosed || failures != 0 {
+		t.Errorf("got breaker state %d failures %d, want closed without failure", state, failures)
+	}
+	if resp := <-done; resp == nil || resp.funcCode != FCWriteSingleRegister {
+		t.Errorf("got response %+v, want write response", resp)
+	}
+}
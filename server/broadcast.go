@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Check function code can be broadcast, only writes without data in response
+func isBroadcastFunction(funcCode uint8) bool {
+	switch funcCode {
+	case FCWriteSingleCoil,
+		FCWriteMultipleCoils,
+		FCWriteSingleRegister,
+		FCWriteMultipleRegisters,
+		FCMaskWriteRegister:
+		return true
+	}
+	return false
+}
+
+// Devices never respond to broadcast, build normal write response for it
+func broadcastResponse(req *pdu) *pdu {
+	payload := req.payload
+	switch req.funcCode {
+	case FCWriteMultipleCoils, FCWriteMultipleRegisters:
+		// Only address and quantity
+		if len(payload) > 4 {
+			payload = payload[0:4]
+		}
+	}
+	return &pdu{
+		unitID:   req.unitID,
+		funcCode: req.funcCode,
+		payload:  append([]byte(nil), payload...),
+	}
+}
+
+// Send broadcast write to serial backends, and to every unit mapped to TCP
+// backends if fan out is enabled. Gateway answers the client after all
+// requests are sent, failures are only logged.
+func (r *Router) requestBroadcast(ctx context.Context, client *clientInfo, req *pdu) (*pdu, error) {
+	bcfg := r.cfg.GetBroadcast()
+	if bcfg == nil || !r.cfg.IsBroadcastAllowed(client.listener) {
+		return r.respModbusError(0, req, MErrGWTargetFailedToRespond), nil
+	}
+	if !isBroadcastFunction(req.funcCode) {
+		return r.respModbusError(0, req, MErrIllegalFunction), nil
+	}
+	if _, err := requestRanges(req); err != nil {
+		return r.respModbusError(0, req, MErrIllegalDataValue), nil
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range bcfg.Backends {
+		backend := r.getBackend(name)
+		if backend == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(backend *Backend) {
+			defer wg.Done()
+			// Devices never answer broadcast, so it is not an outcome
+			// for circuit breaker
+			resp, err := backend.execute(ctx, client, copyPdu(req))
+			backend.invalidateShadow(req)
+			if err != nil || resp == nil || resp.funcCode != req.funcCode {
+				log.Printf("Broadcast to backend %s got error: %v, response: %v", backend.Name, err, resp)
+			}
+		}(backend)
+	}
+	if bcfg.FanOut {
+		for _, uid := range r.fanOutUnitIDs(client.listener) {
+			wg.Add(1)
+			go func(uid uint8) {
+				defer wg.Done()
+				resp, err := r.RequestBackend(ctx, client, uid, copyPdu(req))
+				if err != nil || resp == nil || resp.funcCode != req.funcCode {
+					log.Printf("Broadcast to unit %d got error: %v, response: %v", uid, err, resp)
+				}
+			}(uid)
+		}
+	}
+	wg.Wait()
+	// Broadcast may change any unit on serial bus
+	if len(bcfg.Backends) > 0 {
+		r.cache.clear()
+	}
+	return broadcastResponse(req), nil
+}
+
+// Returns Unit IDs of the listener mapped to TCP backends only, catch all
+// unit map is skipped.
+func (r *Router) fanOutUnitIDs(listener string) []uint8 {
+	var ret []uint8
+	for uid := 1; uid <= 255; uid++ {
+		umap, back := r.cfg.GetUnitIDMap(listener, uint8(uid))
+		if umap == nil || back == nil || umap.IsCatchAll() {
+			continue
+		}
+		if r.isTCPUnitMap(umap) {
+			ret = append(ret, uint8(uid))
+		}
+	}
+	return ret
+}
+
+func (r *Router) isTCPUnitMap(umap *config.UnitMap) bool {
+	names := append([]string(nil), umap.Backends...)
+	for _, rng := range umap.Ranges {
+		names = append(names, rng.Backend)
+	}
+	for _, name := range names {
+		bcfg := r.cfg.GetBackendByName(name)
+		if bcfg == nil || (bcfg.Protocol != "tcp" && bcfg.Protocol != "tls") {
+			return false
+		}
+	}
+	return len(names) > 0
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// RTU bus device server, returns broadcast requests received
+func fakeRTUBus(t *testing.T) (string, chan *pdu) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan *pdu, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				for {
+					req, err := readRTURequest(conn)
+					if err != nil {
+						return
+					}
+					if req.unitID == 0 {
+						got <- req
+						continue
+					}
+					conn.Write(encodeRTUFrame(req))
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestRequestBroadcastListener(t *testing.T) {
+	addr, got := fakeRTUBus(t)
+	yml := `
+listeners:
+  - name: open
+    address: 127.0.0.1:0
+    broadcast: true
+  - name: limited
+    address: 127.0.0.1:0
+    unit_ids: 1-5
+backends:
+  - name: bus
+    protocol: rtu_over_tcp
+    address: ` + addr + `
+    timeout: 1000
+    turnaround_delay: 10
+    circuit_breaker:
+      failure_threshold: 1
+unit_map:
+  - unit_id: 1
+    backend: bus
+broadcast:
+  backends: [bus]
+`
+	fname := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(fname, []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.NewConfig(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter(cfg)
+	defer r.Stop()
+	// Broadcast is not answered by device, so it should not close half
+	// open breaker
+	breaker := r.getBackend("bus").breaker
+	breaker.lock.Lock()
+	breaker.state = breakerHalfOpen
+	breaker.lock.Unlock()
+
+	req := &pdu{unitID: 0, funcCode: FCWriteSingleRegister, payload: []byte{0x00, 0x01, 0x00, 0x07}}
+	tests := []struct {
+		listener string
+		resp     *pdu
+		sent     bool
+	}{
+		{"limited", modbusErrorPdu(req, MErrGWTargetFailedToRespond), false},
+		{"unknown", modbusErrorPdu(req, MErrGWTargetFailedToRespond), false},
+		{"open", broadcastResponse(req), true},
+	}
+	for _, tt := range tests {
+		resp, err := r.RequestBackend(context.Background(), &clientInfo{listener: tt.listener}, 0, copyPdu(req))
+		if err != nil || resp == nil || !equalPdu(resp, tt.resp) {
+			t.Errorf("%s: got %+v %v, want %+v", tt.listener, resp, err, tt.resp)
+		}
+		var sent *pdu
+		if tt.sent {
+			select {
+			case sent = <-got:
+			case <-time.After(time.Second):
+			}
+		} else {
+			select {
+			case sent = <-got:
+			default:
+			}
+		}
+		if (sent != nil) != tt.sent {
+			t.Errorf("%s: got request on bus %+v, want sent %t", tt.listener, sent, tt.sent)
+		}
+	}
+	if state, _ := breakerState(breaker); state != breakerHalfOpen {
+		t.Errorf("got breaker state %d, want half open", state)
+	}
+}
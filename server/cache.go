@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Purge expired entries when unit cache grows over this size
+	maxUnitCacheEntries = 1024
+)
+
+type cacheKey struct {
+	funcCode uint8
+	address  int
+	count    int
+}
+
+type cacheEntry struct {
+	resp     *pdu
+	rng      addressRange
+	expireAt time.Time
+}
+
+// Cached read responses of one target unit on backends. Generation is
+// increased by every write, so reads started before the write will not
+// be stored.
+type unitCache struct {
+	entries  map[cacheKey]*cacheEntry
+	gen      uint64
+	backends []string
+	unitID   uint8
+}
+
+// Read response cache, keyed by backend, target unit, function code,
+// address and quantity.
+type readCache struct {
+	units map[string]*unitCache
+	lock  sync.Mutex
+}
+
+func newReadCache() *readCache {
+	return &readCache{
+		units: map[string]*unitCache{},
+	}
+}
+
+func unitCacheKey(backends []string, uid uint8) string {
+	return fmt.Sprintf("%s/%d", strings.Join(backends, ","), uid)
+}
+
+func copyPdu(p *pdu) *pdu {
+	return &pdu{
+		unitID:   p.unitID,
+		funcCode: p.funcCode,
+		payload:  append([]byte(nil), p.payload...),
+	}
+}
+
+func (c *readCache) getUnit(backends []string, uid uint8) *unitCache {
+	unit := unitCacheKey(backends, uid)
+	uc, have := c.units[unit]
+	if !have {
+		uc = &unitCache{
+			entries:  map[cacheKey]*cacheEntry{},
+			backends: backends,
+			unitID:   uid,
+		}
+		c.units[unit] = uc
+	}
+	return uc
+}
+
+// Returns cached response or nil, and current generation of the unit
+func (c *readCache) get(backends []string, uid uint8, rng addressRange, funcCode uint8) (*pdu, uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	uc := c.getUnit(backends, uid)
+	key := cacheKey{funcCode, rng.address, rng.count}
+	entry, have := uc.entries[key]
+	if !have {
+		return nil, uc.gen
+	}
+	if time.Now().After(entry.expireAt) {
+		delete(uc.entries, key)
+		return nil, uc.gen
+	}
+	return copyPdu(entry.resp), uc.gen
+}
+
+// Store response if no write happened since generation
+func (c *readCache) put(backends []string, uid uint8, rng addressRange, resp *pdu, ttl time.Duration, gen uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	uc := c.getUnit(backends, uid)
+	if uc.gen != gen {
+		return
+	}
+	now := time.Now()
+	if len(uc.entries) >= maxUnitCacheEntries {
+		for key, entry := range uc.entries {
+			if now.After(entry.expireAt) {
+				delete(uc.entries, key)
+			}
+		}
+	}
+	key := cacheKey{resp.funcCode, rng.address, rng.count}
+	uc.entries[key] = &cacheEntry{
+		resp:     copyPdu(resp),
+		rng:      rng,
+		expireAt: now.Add(ttl),
+	}
+}
+
+// Remove entries overlapped with written ranges of the target unit on any
+// of the backends, failover groups share backends with single backends.
+func (c *readCache) invalidate(backends []string, uid uint8, ranges []addressRange) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, uc := range c.units {
+		if uc.unitID != uid || !sharesBackend(uc.backends, backends) {
+			continue
+		}
+		uc.gen++
+		for key, entry := range uc.entries {
+			for _, rng := range ranges {
+				if entry.rng.overlaps(rng) {
+					delete(uc.entries, key)
+					break
+				}
+			}
+		}
+	}
+}
+
+func sharesBackend(a []string, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type cacheTTLKey struct{}
+
+// Returns context carries cache TTL (ms) of read request
+func withCacheTTL(ctx context.Context, ttl int) context.Context {
+	return context.WithValue(ctx, cacheTTLKey{}, ttl)
+}
+
+func cacheTTL(ctx context.Context) int {
+	ttl, _ := ctx.Value(cacheTTLKey{}).(int)
+	return ttl
+}
+
+// Serve read request from cache if context carries cache TTL, write request
+// invalidates cached responses of overlapped ranges. Request is the one sent
+// to backends, so all unit maps of same backend and target unit share cache.
+func (c *readCache) request(ctx context.Context, backends []string, req *pdu, fn func() (*pdu, error)) (*pdu, error) {
+	ranges, err := requestRanges(req)
+	if err != nil || len(ranges) == 0 {
+		return fn()
+	}
+	if isWriteFunction(req.funcCode) {
+		// Write may be applied even if got error, so always invalidate
+		resp, err := fn()
+		c.invalidate(backends, req.unitID, ranges)
+		return resp, err
+	}
+	ttl := cacheTTL(ctx)
+	if ttl <= 0 || !isReadFunction(req.funcCode) {
+		return fn()
+	}
+	cached, gen := c.get(backends, req.unitID, ranges[0], req.funcCode)
+	if cached != nil {
+		return cached, nil
+	}
+	resp, err := fn()
+	// Do not cache exceptions
+	if err == nil && resp != nil && resp.funcCode == req.funcCode {
+		c.put(backends, req.unitID, ranges[0], resp, time.Duration(ttl)*time.Millisecond, gen)
+	}
+	return resp, err
+}
+
+func (c *readCache) clear() {
+	c.lock.Lock()
+	c.units = map[string]*unitCache{}
+	c.lock.Unlock()
+}
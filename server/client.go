@@ -0,0 +1,39 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+)
+
+const (
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+// Information of the client connection which sends the request
+type clientInfo struct {
+	listener   string
+	remoteAddr string
+	// Verified client certificate for TLS listener
+	cert     *x509.Certificate
+	identity string
+	role     string
+}
+
+// Finish TLS handshake and fill the client certificate identity
+func (c *clientInfo) handshake(conn *tls.Conn) error {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	state := conn.ConnectionState()
+	// Only verified certificates can be used as identity
+	if len(state.VerifiedChains) == 0 || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	c.cert = state.PeerCertificates[0]
+	c.identity = c.cert.Subject.CommonName
+	c.role = certificateRole(c.cert)
+	return nil
+}
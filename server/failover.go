@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Failover state for ordered backends, first one is primary
+type failoverGroup struct {
+	backends []string
+	active   int
+	switchAt time.Time
+	lock     sync.Mutex
+}
+
+// Returns backend index order for this request. If failback is enabled,
+// failback delay is passed and primary is healthy, primary will be tried first.
+func (g *failoverGroup) order(failback time.Duration, primaryHealthy bool) []int {
+	g.lock.Lock()
+	start := g.active
+	if g.active != 0 && failback > 0 && primaryHealthy && time.Since(g.switchAt) >= failback {
+		start = 0
+		// Only one request probe primary in failback delay
+		g.switchAt = time.Now()
+	}
+	g.lock.Unlock()
+
+	ret := make([]int, 0, len(g.backends))
+	ret = append(ret, start)
+	for i := range g.backends {
+		if i != start {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+func (g *failoverGroup) setActive(idx int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.active == idx {
+		return
+	}
+	log.Printf("Failover group [%s] switch from %s to %s", strings.Join(g.backends, ", "), g.backends[g.active], g.backends[idx])
+	g.active = idx
+	g.switchAt = time.Now()
+}
+
+func (r *Router) getFailoverGroup(backends []string) *failoverGroup {
+	key := strings.Join(backends, ",")
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	group, have := r.groups[key]
+	if !have {
+		group = &failoverGroup{
+			backends: backends,
+		}
+		r.groups[key] = group
+	}
+	return group
+}
+
+// Send request to active backend of failover group, switch to next backend
+// if got gateway exception.
+func (r *Router) requestFailover(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu) (*pdu, error) {
+	var (
+		resp *pdu
+		err  error
+	)
+	group := r.getFailoverGroup(umap.Backends)
+	failback := time.Duration(umap.Failback) * time.Millisecond
+	primary := r.getBackend(group.backends[0])
+	primaryHealthy := primary != nil && primary.IsHealthy()
+	for _, idx := range group.order(failback, primaryHealthy) {
+		// No time left to try next backend
+		if ctx.Err() != nil {
+			break
+		}
+		backend := r.getBackend(group.backends[idx])
+		// Skip backends marked down by health check
+		if backend == nil || !backend.IsHealthy() {
+			continue
+		}
+		resp, err = backend.ExecuteRequest(ctx, client, req)
+		if err == nil && !isGatewayError(resp) {
+			group.setActive(idx)
+			return resp, err
+		}
+	}
+	if resp == nil {
+		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), err
+	}
+	return resp, err
+}
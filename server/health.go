@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is healthy if health check is not configured or last check passed
+func (b *Backend) IsHealthy() bool {
+	return atomic.LoadInt32(&b.unhealthy) == 0
+}
+
+func (b *Backend) setHealthy(healthy bool) {
+	var val int32 = 1
+	if healthy {
+		val = 0
+	}
+	old := atomic.SwapInt32(&b.unhealthy, val)
+	if old == val {
+		return
+	}
+	if healthy {
+		log.Printf("Backend %s is up", b.Name)
+	} else {
+		log.Printf("Backend %s is down", b.Name)
+	}
+}
+
+func (b *Backend) runHealthCheck() {
+	hc := b.bcfg.HealthCheck
+	ticker := time.NewTicker(time.Duration(hc.Interval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		b.checkHealth()
+		select {
+		case <-b.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Backend) checkHealth() {
+	hc := b.bcfg.HealthCheck
+	payload := uint16ToBytes(BIG_ENDIAN, uint16(hc.Address))
+	payload = append(payload, uint16ToBytes(BIG_ENDIAN, uint16(hc.Count))...)
+	req := &pdu{
+		unitID:   uint8(hc.UnitID),
+		funcCode: uint8(hc.FunctionCode),
+		payload:  payload,
+	}
+	// Health check should not affected by circuit breaker
+	resp, err := b.execute(context.Background(), nil, req)
+	if !b.running {
+		return
+	}
+	// Modbus exception except gateway ones means device is alive
+	healthy := err == nil && resp != nil && !isGatewayError(resp)
+	if !healthy && err != nil {
+		log.Printf("Health check backend %s got error: %v", b.Name, err)
+	}
+	b.setHealthy(healthy)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"log"
+	"sync"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+type runningServer struct {
+	key    string
+	server Server
+}
+
+// Manage servers for listeners in config file
+type ListenerManager struct {
+	cfg           *config.Config
+	router        *Router
+	defaultListen string
+	timeout       int
+	servers       map[string]*runningServer
+	lock          sync.Mutex
+}
+
+func NewListenerManager(cfg *config.Config, router *Router, defaultListen string, timeout int) *ListenerManager {
+	return &ListenerManager{
+		cfg:           cfg,
+		router:        router,
+		defaultListen: defaultListen,
+		timeout:       timeout,
+		servers:       map[string]*runningServer{},
+	}
+}
+
+func (m *ListenerManager) getListeners() []*config.Listener {
+	listeners := m.cfg.GetListeners()
+	if len(listeners) > 0 {
+		return listeners
+	}
+	// No listener in config file, use default listen address
+	l := &config.Listener{
+		Address: m.defaultListen,
+	}
+	l.FillDefaults()
+	return []*config.Listener{l}
+}
+
+func (m *ListenerManager) startServer(l *config.Listener) error {
+	srv := NewServer(l, m.timeout, m.router)
+	err := srv.Start()
+	if err != nil {
+		return err
+	}
+	m.servers[l.Name] = &runningServer{
+		key:    l.GetListenerKey(),
+		server: srv,
+	}
+	log.Printf("Start %s server %s at %s", l.Protocol, l.Name, l.Address)
+	return nil
+}
+
+func (m *ListenerManager) stopServer(name string) {
+	rs, have := m.servers[name]
+	if !have {
+		return
+	}
+	err := rs.server.Stop()
+	if err != nil {
+		log.Printf("Stop server %s got error: %v", name, err)
+	}
+	delete(m.servers, name)
+	log.Printf("Stop server %s", name)
+}
+
+func (m *ListenerManager) Start() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, l := range m.getListeners() {
+		err := m.startServer(l)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *ListenerManager) Reload() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	listeners := m.getListeners()
+	wanted := map[string]*config.Listener{}
+	for _, l := range listeners {
+		wanted[l.Name] = l
+	}
+	// Stop removed or changed servers first, so address can be re-bound
+	for name, rs := range m.servers {
+		l, have := wanted[name]
+		if have && l.GetListenerKey() == rs.key {
+			continue
+		}
+		m.stopServer(name)
+	}
+	for _, l := range listeners {
+		if _, have := m.servers[l.Name]; have {
+			continue
+		}
+		err := m.startServer(l)
+		if err != nil {
+			log.Printf("Start %s server %s got error: %v", l.Protocol, l.Name, err)
+		}
+	}
+}
+
+func (m *ListenerManager) Stop() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for name := range m.servers {
+		m.stopServer(name)
+	}
+}
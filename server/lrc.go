@@ -0,0 +1,34 @@
+package server
+
+type lrc struct {
+	sum uint8
+}
+
+// Prepares the LRC generator for use.
+func (l *lrc) init() {
+	l.sum = 0
+
+	return
+}
+
+// Adds the given bytes to the LRC.
+func (l *lrc) add(in []byte) {
+	for _, b := range in {
+		l.sum += b
+	}
+
+	return
+}
+
+// Returns the LRC as one byte, two's complement of the sum.
+func (l *lrc) value() (value byte) {
+	value = uint8(-int8(l.sum))
+
+	return
+}
+
+func (l *lrc) isEqual(in byte) (yes bool) {
+	yes = (l.value() == in)
+
+	return
+}
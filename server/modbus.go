@@ -50,6 +50,14 @@ const (
 	FCReadFileRecord  uint8 = 0x14
 	FCWriteFileRecord uint8 = 0x15
 
+	// diagnostics
+	FCReadExceptionStatus   uint8 = 0x07
+	FCDiagnostics           uint8 = 0x08
+	FCGetCommEventCounter   uint8 = 0x0b
+	FCGetCommEventLog       uint8 = 0x0c
+	FCReportServerID        uint8 = 0x11
+	FCEncapsulatedInterface uint8 = 0x2b
+
 	// Error codes
 	MErrIllegalFunction         uint8 = 0x01
 	MErrIllegalDataAddress      uint8 = 0x02
@@ -83,6 +91,33 @@ func bytesToUint16(endianness Endianness, in []byte) (out uint16) {
 	return
 }
 
+// Check function code will change data of device
+func isWriteFunction(funcCode uint8) bool {
+	switch funcCode {
+	case FCWriteSingleCoil,
+		FCWriteMultipleCoils,
+		FCWriteSingleRegister,
+		FCWriteMultipleRegisters,
+		FCMaskWriteRegister,
+		FCReadWriteMultipleRegisters,
+		FCWriteFileRecord:
+		return true
+	}
+	return false
+}
+
+// Check function code reads bits or registers from data table
+func isReadFunction(funcCode uint8) bool {
+	switch funcCode {
+	case FCReadCoils,
+		FCReadDiscreteInputs,
+		FCReadHoldingRegisters,
+		FCReadInputRegisters:
+		return true
+	}
+	return false
+}
+
 // Computes the expected length of a modbus RTU response.
 func calculateResponseBytes(responseCode uint8, responseLength uint8) (byteCount int, err error) {
 	switch responseCode {
@@ -92,8 +127,15 @@ func calculateResponseBytes(responseCode uint8, responseLength uint8) (byteCount
 		FCReadDiscreteInputs,
 		FCReadFileRecord,
 		FCWriteFileRecord,
-		FCReadWriteMultipleRegisters:
+		FCReadWriteMultipleRegisters,
+		FCGetCommEventLog,
+		FCReportServerID:
 		byteCount = int(responseLength)
+	case FCReadExceptionStatus:
+		byteCount = 0
+	case FCDiagnostics,
+		FCGetCommEventCounter:
+		byteCount = 3
 	case FCWriteSingleRegister,
 		FCWriteMultipleRegisters,
 		FCWriteSingleCoil,
@@ -118,7 +160,57 @@ func calculateResponseBytes(responseCode uint8, responseLength uint8) (byteCount
 	case FCReadFifoQueue:
 		err = ErrNeedReadMore
 	default:
-		err = ErrProtocolError
+		// Exception response of any function code has only exception code
+		if responseCode&0x80 != 0 {
+			byteCount = 0
+		} else {
+			err = ErrProtocolError
+		}
+	}
+	return
+}
+
+// Computes the expected length of a modbus request payload (without function
+// code). Returns the fixed bytes and the position of byte count field in
+// payload, -1 means there is no byte count field.
+func calculateRequestBytes(requestCode uint8) (fixedBytes int, countPos int, err error) {
+	countPos = -1
+	switch requestCode {
+	case FCReadCoils,
+		FCReadDiscreteInputs,
+		FCReadHoldingRegisters,
+		FCReadInputRegisters,
+		FCWriteSingleCoil,
+		FCWriteSingleRegister:
+		fixedBytes = 4
+	case FCWriteMultipleCoils,
+		FCWriteMultipleRegisters:
+		fixedBytes = 5
+		countPos = 4
+	case FCMaskWriteRegister:
+		fixedBytes = 6
+	case FCReadWriteMultipleRegisters:
+		fixedBytes = 9
+		countPos = 8
+	case FCReadFifoQueue:
+		fixedBytes = 2
+	case FCReadFileRecord,
+		FCWriteFileRecord:
+		fixedBytes = 1
+		countPos = 0
+	case FCReadExceptionStatus,
+		FCGetCommEventCounter,
+		FCGetCommEventLog,
+		FCReportServerID:
+		fixedBytes = 0
+	case FCDiagnostics:
+		// Sub-function and data
+		fixedBytes = 4
+	case FCEncapsulatedInterface:
+		// MEI type, read device ID code and object ID
+		fixedBytes = 3
+	default:
+		err = ErrIllegalFunction
 	}
 	return
 }
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// Protocol limits of read quantity
+	maxReadRegisters = 125
+	maxReadBits      = 2000
+
+	// Default queue size when merge is enabled, so queued reads can wait
+	// for merging
+	defaultMergeQueueSize = 64
+)
+
+func isBitFunction(funcCode uint8) bool {
+	return funcCode == FCReadCoils || funcCode == FCReadDiscreteInputs
+}
+
+// Returns address and quantity of read request (function code 1-4)
+func readRange(req *pdu) (int, int, bool) {
+	if !isReadFunction(req.funcCode) || len(req.payload) != 4 {
+		return 0, 0, false
+	}
+	address := int(bytesToUint16(BIG_ENDIAN, req.payload[0:2]))
+	count := int(bytesToUint16(BIG_ENDIAN, req.payload[2:4]))
+	return address, count, true
+}
+
+func newReadRequest(unitID uint8, funcCode uint8, address int, count int) *pdu {
+	payload := uint16ToBytes(BIG_ENDIAN, uint16(address))
+	payload = append(payload, uint16ToBytes(BIG_ENDIAN, uint16(count))...)
+	return &pdu{
+		unitID:   unitID,
+		funcCode: funcCode,
+		payload:  payload,
+	}
+}
+
+// Returns data of read response, bit is 1 byte (0 or 1) and register is
+// 2 bytes. Returns false if response is exception or invalid.
+func readResponseValues(req *pdu, resp *pdu, count int) ([]byte, bool) {
+	if resp == nil || resp.funcCode != req.funcCode || len(resp.payload) < 1 {
+		return nil, false
+	}
+	byteCount := int(resp.payload[0])
+	data := resp.payload[1:]
+	if isBitFunction(req.funcCode) {
+		if byteCount != (count+7)/8 || len(data) < byteCount {
+			return nil, false
+		}
+		values := make([]byte, count)
+		for i := range values {
+			values[i] = (data[i/8] >> uint(i%8)) & 1
+		}
+		return values, true
+	}
+	if byteCount != count*2 || len(data) < byteCount {
+		return nil, false
+	}
+	return append([]byte(nil), data[:byteCount]...), true
+}
+
+// Build read response from values returned by readResponseValues
+func newReadResponse(req *pdu, values []byte) *pdu {
+	var data []byte
+	if isBitFunction(req.funcCode) {
+		data = make([]byte, (len(values)+7)/8)
+		for i, v := range values {
+			data[i/8] |= v << uint(i%8)
+		}
+	} else {
+		data = values
+	}
+	return &pdu{
+		unitID:   req.unitID,
+		funcCode: req.funcCode,
+		payload:  append([]byte{byte(len(data))}, data...),
+	}
+}
+
+// Exception code of response, invalid response is path unavailable
+func exceptionCode(resp *pdu) uint8 {
+	if resp != nil && resp.funcCode&0x80 != 0 && len(resp.payload) > 0 {
+		return resp.payload[0]
+	}
+	return MErrGWPathUnavailable
+}
+
+// Returns max quantity of read request for device
+func (b *Backend) maxReadQuantity(funcCode uint8) int {
+	if isBitFunction(funcCode) {
+		return b.bcfg.Optimizer.MaxBits
+	}
+	return b.bcfg.Optimizer.MaxRegisters
+}
+
+// Execute request with optimizer, queued adjacent reads of same unit are
+// merged into one read, read larger than device max quantity is split.
+func (b *Backend) executeOptimized(trans Transport, mreq *modbusRequest) {
+	req := mreq.req
+	address, count, ok := readRange(req)
+	if !ok {
+		mreq.reply(b.executeWithRetry(mreq.ctx, trans, req))
+		return
+	}
+	maxQuantity := b.maxReadQuantity(req.funcCode)
+	protocolLimit := maxReadRegisters
+	if isBitFunction(req.funcCode) {
+		protocolLimit = maxReadBits
+	}
+	// Invalid quantity is sent to device as is
+	if count < 1 || count > protocolLimit {
+		mreq.reply(b.executeWithRetry(mreq.ctx, trans, req))
+		return
+	}
+	if count > maxQuantity {
+		mreq.reply(b.executeSplit(mreq, trans, address, count, maxQuantity))
+		return
+	}
+	if !b.bcfg.Optimizer.Merge {
+		mreq.reply(b.executeWithRetry(mreq.ctx, trans, req))
+		return
+	}
+
+	gap := b.bcfg.Optimizer.MergeGap
+	start, end := address, address+count
+	batch := []*modbusRequest{mreq}
+	batch = append(batch, b.sched.take(func(o *modbusRequest) bool {
+		if o.req.unitID != req.unitID || o.req.funcCode != req.funcCode || b.isExpired(o) {
+			return false
+		}
+		oaddr, ocount, ok := readRange(o.req)
+		if !ok || ocount < 1 || oaddr > end+gap || oaddr+ocount < start-gap {
+			return false
+		}
+		nstart, nend := min(start, oaddr), max(end, oaddr+ocount)
+		if nend-nstart > maxQuantity || !o.dispatch() {
+			return false
+		}
+		start, end = nstart, nend
+		return true
+	})...)
+	if len(batch) == 1 {
+		mreq.reply(b.executeWithRetry(mreq.ctx, trans, req))
+		return
+	}
+	b.executeMerged(trans, batch, start, end-start)
+}
+
+// Execute one read for the merged range and split response to requests
+func (b *Backend) executeMerged(trans Transport, batch []*modbusRequest, address int, count int) {
+	first := batch[0].req
+	merged := newReadRequest(first.unitID, first.funcCode, address, count)
+	ctx, cancel := mergedContext(batch)
+	resp, err := b.executeWithRetry(ctx, trans, merged)
+	cancel()
+	values, ok := readResponseValues(merged, resp, count)
+	if err == nil && !ok && resp != nil && resp.funcCode&0x80 != 0 && !isGatewayError(resp) {
+		// Device rejects merged range (such as gap is not readable), so
+		// send requests one by one
+		for _, mreq := range batch {
+			mreq.reply(b.executeWithRetry(mreq.ctx, trans, mreq.req))
+		}
+		return
+	}
+	if err == nil && !ok && exceptionCode(resp) == MErrGWPathUnavailable {
+		err = ErrProtocolError
+	}
+	for _, mreq := range batch {
+		if !ok {
+			mreq.reply(modbusErrorPdu(mreq.req, exceptionCode(resp)), err)
+			continue
+		}
+		oaddr, ocount, _ := readRange(mreq.req)
+		offset := oaddr - address
+		var part []byte
+		if isBitFunction(first.funcCode) {
+			part = values[offset : offset+ocount]
+		} else {
+			part = values[offset*2 : (offset+ocount)*2]
+		}
+		mreq.reply(newReadResponse(mreq.req, part), nil)
+	}
+}
+
+// Merged read is not stopped by deadline of one request, it is detached
+// from requests and expires with the latest deadline of them
+func mergedContext(batch []*modbusRequest) (context.Context, context.CancelFunc) {
+	var latest time.Time
+	for _, mreq := range batch {
+		deadline, ok := mreq.ctx.Deadline()
+		if !ok {
+			return context.WithCancel(context.Background())
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	return context.WithDeadline(context.Background(), latest)
+}
+
+// Split read into several reads no larger than max quantity
+func (b *Backend) executeSplit(mreq *modbusRequest, trans Transport, address int, count int, maxQuantity int) (*pdu, error) {
+	req := mreq.req
+	var values []byte
+	for offset := 0; offset < count; offset += maxQuantity {
+		n := min(maxQuantity, count-offset)
+		sub := newReadRequest(req.unitID, req.funcCode, address+offset, n)
+		resp, err := b.executeWithRetry(mreq.ctx, trans, sub)
+		part, ok := readResponseValues(sub, resp, n)
+		if !ok {
+			if err == nil && exceptionCode(resp) == MErrGWPathUnavailable {
+				err = ErrProtocolError
+			}
+			return modbusErrorPdu(req, exceptionCode(resp)), err
+		}
+		values = append(values, part...)
+	}
+	return newReadResponse(req, values), nil
+}
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Device memory for read tests, register at address a is a*7+1 and bit
+// at address a is 1 if a is multiple of 3. Reading hole address returns
+// illegal data address exception.
+type memoryTransport struct {
+	holes map[int]bool
+	resp  *pdu
+	// Number of requests fail with frame error
+	fails int
+	reqs  []*pdu
+}
+
+func (m *memoryTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	m.reqs = append(m.reqs, req)
+	if m.fails > 0 {
+		m.fails--
+		return nil, ErrProtocolError
+	}
+	if m.resp != nil {
+		return m.resp, nil
+	}
+	address, count, ok := readRange(req)
+	if !ok {
+		return modbusErrorPdu(req, MErrIllegalFunction), nil
+	}
+	var values []byte
+	for a := address; a < address+count; a++ {
+		if m.holes[a] {
+			return modbusErrorPdu(req, MErrIllegalDataAddress), nil
+		}
+		values = append(values, memoryValue(req.funcCode, a)...)
+	}
+	return newReadResponse(req, values), nil
+}
+
+func (m *memoryTransport) Close() error {
+	return nil
+}
+
+func memoryValue(funcCode uint8, address int) []byte {
+	if isBitFunction(funcCode) {
+		if address%3 == 0 {
+			return []byte{1}
+		}
+		return []byte{0}
+	}
+	return uint16ToBytes(BIG_ENDIAN, uint16(address*7+1))
+}
+
+// Expected response of reading device memory directly
+func memoryResponse(req *pdu) *pdu {
+	address, count, _ := readRange(req)
+	var values []byte
+	for a := address; a < address+count; a++ {
+		values = append(values, memoryValue(req.funcCode, a)...)
+	}
+	return newReadResponse(req, values)
+}
+
+func newOptimizerBackend(opt *config.Optimizer) *Backend {
+	opt.FillDefaults()
+	return &Backend{
+		Name: "test",
+		bcfg: &config.Backend{
+			Name:      "test",
+			Retry:     &config.RetryPolicy{MaxAttempts: 1},
+			Optimizer: opt,
+		},
+		sched: newScheduler(nil, 16),
+	}
+}
+
+func newOptimizerRequest(funcCode uint8, address int, count int) *modbusRequest {
+	return &modbusRequest{
+		ctx:    context.Background(),
+		req:    newReadRequest(1, funcCode, address, count),
+		respCh: make(chan *modbusResponse, 1),
+	}
+}
+
+func TestReadResponseBits(t *testing.T) {
+	tests := []struct {
+		values  []byte
+		payload []byte
+	}{
+		{[]byte{1}, []byte{0x01, 0x01}},
+		{[]byte{1, 0, 1, 1, 0, 0, 1, 0}, []byte{0x01, 0x4d}},
+		{[]byte{1, 0, 1, 1, 0, 0, 1, 0, 1}, []byte{0x02, 0x4d, 0x01}},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, []byte{0x02, 0x00, 0x08}},
+	}
+	for _, tt := range tests {
+		req := newReadRequest(1, FCReadCoils, 0, len(tt.values))
+		resp := newReadResponse(req, tt.values)
+		if !bytes.Equal(resp.payload, tt.payload) {
+			t.Errorf("newReadResponse(%v) payload = % x, want % x", tt.values, resp.payload, tt.payload)
+		}
+		values, ok := readResponseValues(req, resp, len(tt.values))
+		if !ok || !bytes.Equal(values, tt.values) {
+			t.Errorf("readResponseValues(% x) = %v %v, want %v", tt.payload, values, ok, tt.values)
+		}
+	}
+}
+
+func TestReadResponseValuesInvalid(t *testing.T) {
+	bits := newReadRequest(1, FCReadDiscreteInputs, 0, 9)
+	regs := newReadRequest(1, FCReadHoldingRegisters, 0, 2)
+	tests := []struct {
+		name string
+		req  *pdu
+		resp *pdu
+	}{
+		{"nil", regs, nil},
+		{"exception", regs, modbusErrorPdu(regs, MErrIllegalDataAddress)},
+		{"other function", regs, &pdu{unitID: 1, funcCode: FCReadInputRegisters, payload: []byte{0x04, 0, 1, 0, 2}}},
+		{"empty", regs, &pdu{unitID: 1, funcCode: FCReadHoldingRegisters, payload: []byte{}}},
+		{"register count", regs, &pdu{unitID: 1, funcCode: FCReadHoldingRegisters, payload: []byte{0x02, 0, 1}}},
+		{"register short", regs, &pdu{unitID: 1, funcCode: FCReadHoldingRegisters, payload: []byte{0x04, 0, 1}}},
+		{"bit count", bits, &pdu{unitID: 1, funcCode: FCReadDiscreteInputs, payload: []byte{0x01, 0xff}}},
+		{"bit short", bits, &pdu{unitID: 1, funcCode: FCReadDiscreteInputs, payload: []byte{0x02, 0xff}}},
+	}
+	for _, tt := range tests {
+		_, count, _ := readRange(tt.req)
+		if values, ok := readResponseValues(tt.req, tt.resp, count); ok {
+			t.Errorf("%s: got values %v, want invalid", tt.name, values)
+		}
+	}
+}
+
+type readRangeArg struct {
+	address int
+	count   int
+}
+
+func TestExecuteMerged(t *testing.T) {
+	tests := []struct {
+		name     string
+		funcCode uint8
+		reads    []readRangeArg
+		merged   readRangeArg
+	}{
+		{"adjacent registers", FCReadHoldingRegisters, []readRangeArg{{10, 2}, {12, 3}, {15, 1}}, readRangeArg{10, 6}},
+		{"registers with gap", FCReadInputRegisters, []readRangeArg{{100, 1}, {104, 2}}, readRangeArg{100, 6}},
+		{"overlapped registers", FCReadHoldingRegisters, []readRangeArg{{20, 10}, {25, 2}, {18, 4}}, readRangeArg{18, 12}},
+		{"unaligned bits", FCReadCoils, []readRangeArg{{3, 5}, {10, 12}, {8, 1}}, readRangeArg{3, 19}},
+		{"bits across bytes", FCReadDiscreteInputs, []readRangeArg{{17, 1}, {0, 9}}, readRangeArg{0, 18}},
+	}
+	for _, tt := range tests {
+		b := newOptimizerBackend(&config.Optimizer{Merge: true})
+		trans := &memoryTransport{}
+		var batch []*modbusRequest
+		for _, r := range tt.reads {
+			batch = append(batch, newOptimizerRequest(tt.funcCode, r.address, r.count))
+		}
+		b.executeMerged(trans, batch, tt.merged.address, tt.merged.count)
+		want := newReadRequest(1, tt.funcCode, tt.merged.address, tt.merged.count)
+		if len(trans.reqs) != 1 || !equalPdu(trans.reqs[0], want) {
+			t.Errorf("%s: got device requests %+v, want %+v", tt.name, trans.reqs, want)
+		}
+		for _, mreq := range batch {
+			resp := <-mreq.respCh
+			if resp.err != nil || !equalPdu(resp.resp, memoryResponse(mreq.req)) {
+				t.Errorf("%s: request % x got %+v %v, want %+v", tt.name, mreq.req.payload, resp.resp, resp.err, memoryResponse(mreq.req))
+			}
+		}
+	}
+}
+
+func TestExecuteMergedException(t *testing.T) {
+	reads := []readRangeArg{{10, 2}, {14, 2}}
+	tests := []struct {
+		name  string
+		trans *memoryTransport
+		// Requests sent to device
+		sent int
+		// Exception code for every request, 0 for normal response
+		code uint8
+		err  error
+	}{
+		// Gap of merged read is not readable, requests are sent one by one
+		{"device exception", &memoryTransport{holes: map[int]bool{12: true}}, 3, 0, nil},
+		{"gateway exception", &memoryTransport{resp: &pdu{unitID: 1, funcCode: 0x83, payload: []byte{MErrGWTargetFailedToRespond}}}, 1, MErrGWTargetFailedToRespond, nil},
+		{"invalid response", &memoryTransport{resp: &pdu{unitID: 1, funcCode: FCReadHoldingRegisters, payload: []byte{0x02, 0, 1}}}, 1, MErrGWPathUnavailable, ErrProtocolError},
+	}
+	for _, tt := range tests {
+		b := newOptimizerBackend(&config.Optimizer{Merge: true, MergeGap: 2})
+		var batch []*modbusRequest
+		for _, r := range reads {
+			batch = append(batch, newOptimizerRequest(FCReadHoldingRegisters, r.address, r.count))
+		}
+		b.executeMerged(tt.trans, batch, 10, 6)
+		if len(tt.trans.reqs) != tt.sent {
+			t.Errorf("%s: got %d device requests, want %d", tt.name, len(tt.trans.reqs), tt.sent)
+		}
+		for _, mreq := range batch {
+			want := memoryResponse(mreq.req)
+			if tt.code != 0 {
+				want = modbusErrorPdu(mreq.req, tt.code)
+			}
+			resp := <-mreq.respCh
+			if resp.err != tt.err || !equalPdu(resp.resp, want) {
+				t.Errorf("%s: request % x got %+v %v, want %+v %v", tt.name, mreq.req.payload, resp.resp, resp.err, want, tt.err)
+			}
+		}
+	}
+}
+
+func TestExecuteSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		funcCode uint8
+		read     readRangeArg
+		max      int
+		sent     []readRangeArg
+	}{
+		{"registers", FCReadHoldingRegisters, readRangeArg{0, 125}, 50, []readRangeArg{{0, 50}, {50, 50}, {100, 25}}},
+		{"registers exact", FCReadInputRegisters, readRangeArg{7, 20}, 10, []readRangeArg{{7, 10}, {17, 10}}},
+		{"bits", FCReadCoils, readRangeArg{5, 20}, 8, []readRangeArg{{5, 8}, {13, 8}, {21, 4}}},
+		{"bits unaligned max", FCReadDiscreteInputs, readRangeArg{1, 11}, 3, []readRangeArg{{1, 3}, {4, 3}, {7, 3}, {10, 2}}},
+	}
+	for _, tt := range tests {
+		b := newOptimizerBackend(&config.Optimizer{MaxRegisters: tt.max, MaxBits: tt.max})
+		trans := &memoryTransport{}
+		mreq := newOptimizerRequest(tt.funcCode, tt.read.address, tt.read.count)
+		b.executeOptimized(trans, mreq)
+		if len(trans.reqs) != len(tt.sent) {
+			t.Errorf("%s: got %d device requests, want %d", tt.name, len(trans.reqs), len(tt.sent))
+			continue
+		}
+		for i, r := range tt.sent {
+			if want := newReadRequest(1, tt.funcCode, r.address, r.count); !equalPdu(trans.reqs[i], want) {
+				t.Errorf("%s: device request %d = %+v, want %+v", tt.name, i, trans.reqs[i], want)
+			}
+		}
+		resp := <-mreq.respCh
+		if resp.err != nil || !equalPdu(resp.resp, memoryResponse(mreq.req)) {
+			t.Errorf("%s: got %+v %v, want %+v", tt.name, resp.resp, resp.err, memoryResponse(mreq.req))
+		}
+	}
+}
+
+func TestExecuteSplitException(t *testing.T) {
+	b := newOptimizerBackend(&config.Optimizer{MaxRegisters: 10})
+	trans := &memoryTransport{holes: map[int]bool{15: true}}
+	mreq := newOptimizerRequest(FCReadHoldingRegisters, 0, 30)
+	b.executeOptimized(trans, mreq)
+	// Stop at first failed part
+	if len(trans.reqs) != 2 {
+		t.Errorf("got %d device requests, want 2", len(trans.reqs))
+	}
+	resp := <-mreq.respCh
+	if want := modbusErrorPdu(mreq.req, MErrIllegalDataAddress); resp.err != nil || !equalPdu(resp.resp, want) {
+		t.Errorf("got %+v %v, want %+v", resp.resp, resp.err, want)
+	}
+}
+
+func TestExecuteOptimizedMerge(t *testing.T) {
+	b := newOptimizerBackend(&config.Optimizer{Merge: true, MergeGap: 1, MaxRegisters: 10})
+	queued := []*modbusRequest{
+		newOptimizerRequest(FCReadHoldingRegisters, 3, 2),
+		// Other function code
+		newOptimizerRequest(FCReadInputRegisters, 5, 1),
+		// Gap larger than merge gap
+		newOptimizerRequest(FCReadHoldingRegisters, 12, 1),
+		newOptimizerRequest(FCReadHoldingRegisters, 6, 2),
+		// Merged range would exceed max registers
+		newOptimizerRequest(FCReadHoldingRegisters, 8, 5),
+	}
+	for _, mreq := range queued {
+		if err := b.sched.push(mreq, true, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	trans := &memoryTransport{}
+	first := newOptimizerRequest(FCReadHoldingRegisters, 0, 3)
+	b.executeOptimized(trans, first)
+	if want := newReadRequest(1, FCReadHoldingRegisters, 0, 8); len(trans.reqs) != 1 || !equalPdu(trans.reqs[0], want) {
+		t.Errorf("got device requests %+v, want %+v", trans.reqs, want)
+	}
+	for _, mreq := range []*modbusRequest{first, queued[0], queued[3]} {
+		resp := <-mreq.respCh
+		if resp.err != nil || !equalPdu(resp.resp, memoryResponse(mreq.req)) {
+			t.Errorf("request % x got %+v %v, want %+v", mreq.req.payload, resp.resp, resp.err, memoryResponse(mreq.req))
+		}
+	}
+	if s := b.sched.count; s != 3 {
+		t.Errorf("got %d queued requests, want 3", s)
+	}
+}
+
+func TestExecuteMergedRetry(t *testing.T) {
+	b := newOptimizerBackend(&config.Optimizer{Merge: true})
+	b.bcfg.Retry = &config.RetryPolicy{MaxAttempts: 2, Errors: []string{"frame"}}
+	trans := &memoryTransport{fails: 1}
+	// Deadline of first request is exceeded, merged read is still retried
+	// for the others
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	live, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	batch := []*modbusRequest{
+		newOptimizerRequest(FCReadHoldingRegisters, 0, 2),
+		newOptimizerRequest(FCReadHoldingRegisters, 2, 2),
+	}
+	batch[0].ctx = expired
+	batch[1].ctx = live
+	b.executeMerged(trans, batch, 0, 4)
+	if len(trans.reqs) != 2 {
+		t.Errorf("got %d device requests, want 2", len(trans.reqs))
+	}
+	resp := <-batch[1].respCh
+	if resp.err != nil || !equalPdu(resp.resp, memoryResponse(batch[1].req)) {
+		t.Errorf("got %+v %v, want %+v", resp.resp, resp.err, memoryResponse(batch[1].req))
+	}
+}
+
+func TestMergedContext(t *testing.T) {
+	now := time.Now()
+	withDeadline := func(d time.Duration) *modbusRequest {
+		ctx, cancel := context.WithDeadline(context.Background(), now.Add(d))
+		t.Cleanup(cancel)
+		return &modbusRequest{ctx: ctx}
+	}
+	noDeadline := &modbusRequest{ctx: context.Background()}
+	tests := []struct {
+		name     string
+		batch    []*modbusRequest
+		deadline time.Duration
+		ok       bool
+	}{
+		{"latest", []*modbusRequest{withDeadline(time.Second), withDeadline(3 * time.Second), withDeadline(-time.Second)}, 3 * time.Second, true},
+		{"no deadline", []*modbusRequest{withDeadline(time.Second), noDeadline}, 0, false},
+	}
+	for _, tt := range tests {
+		ctx, cancel := mergedContext(tt.batch)
+		deadline, ok := ctx.Deadline()
+		if ok != tt.ok || (ok && !deadline.Equal(now.Add(tt.deadline))) {
+			t.Errorf("%s: got deadline %v %v, want %v %v", tt.name, deadline, ok, now.Add(tt.deadline), tt.ok)
+		}
+		if ctx.Err() != nil {
+			t.Errorf("%s: got context error %v", tt.name, ctx.Err())
+		}
+		cancel()
+	}
+}
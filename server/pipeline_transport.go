@@ -0,0 +1,226 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+var (
+	ErrConnectionClosed = errors.New("Connection closed")
+)
+
+// One pipelined connection, responses are matched to waiters by
+// transaction ID in reader goroutine.
+type pipelineConn struct {
+	conn      net.Conn
+	waiters   map[uint16]chan *modbusResponse
+	lastTxn   uint16
+	closed    bool
+	lastRead  time.Time
+	lock      sync.Mutex
+	writeLock sync.Mutex
+}
+
+// Pipelined TCP transport, many outstanding requests share one connection.
+// It is safe for concurrent use, max in-flight requests is limited by
+// backend workers.
+type pipelineTransport struct {
+	*tcpTransport
+	pconn *pipelineConn
+	plock sync.Mutex
+}
+
+func newPipelineTransport(cfg *config.Backend) *pipelineTransport {
+	return &pipelineTransport{
+		tcpTransport: newTcpTransport(cfg),
+	}
+}
+
+func (pt *pipelineTransport) getConn() (*pipelineConn, error) {
+	pt.plock.Lock()
+	defer pt.plock.Unlock()
+	if pt.pconn != nil {
+		return pt.pconn, nil
+	}
+	addr, err := net.ResolveTCPAddr("tcp", pt.cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pt.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	pc := &pipelineConn{
+		conn:    conn,
+		waiters: map[uint16]chan *modbusResponse{},
+	}
+	pt.pconn = pc
+	go pt.readLoop(pc)
+	return pc, nil
+}
+
+func (pt *pipelineTransport) dropConn(pc *pipelineConn, err error) {
+	pt.plock.Lock()
+	if pt.pconn == pc {
+		pt.pconn = nil
+	}
+	pt.plock.Unlock()
+	pc.close(err)
+}
+
+func (pt *pipelineTransport) readLoop(pc *pipelineConn) {
+	for {
+		resp, vmbap, err := pt.readMBAPFrame(pc.conn)
+		if err == ErrInvalidProtocol {
+			continue
+		}
+		if err != nil {
+			pt.dropConn(pc, err)
+			return
+		}
+		pc.touch()
+		ch := pc.unregister(vmbap.txnID)
+		if ch == nil {
+			log.Printf("Receive unexpected transaction id 0x%04x", vmbap.txnID)
+			continue
+		}
+		ch <- &modbusResponse{
+			resp: resp,
+		}
+	}
+}
+
+func (pt *pipelineTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	pc, err := pt.getConn()
+	if err != nil {
+		log.Println("Connect backend got error:", err)
+		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
+	}
+	resp, err := pt.executeRequestPipeline(pc, req)
+	if err != nil {
+		return modbusErrorPdu(req, MErrGWPathUnavailable), err
+	}
+	return resp, err
+}
+
+func (pt *pipelineTransport) executeRequestPipeline(pc *pipelineConn, req *pdu) (*pdu, error) {
+	ch := make(chan *modbusResponse, 1)
+	txnID, err := pc.register(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	sentAt := time.Now()
+	err = pc.write(pt.encodeMBAPFrame(txnID, req), pt.timeout)
+	if err != nil {
+		pc.unregister(txnID)
+		if isConnectionClosed(err) {
+			pt.dropConn(pc, err)
+		}
+		return nil, err
+	}
+
+	var timeoutCh <-chan time.Time
+	if pt.timeout > 0 {
+		timer := time.NewTimer(pt.timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	select {
+	case resp := <-ch:
+		return resp.resp, resp.err
+	case <-timeoutCh:
+		pc.unregister(txnID)
+		// Backend sent nothing since the request, connection is stuck
+		// so replace it
+		if pc.lastReadTime().Before(sentAt) {
+			pt.dropConn(pc, os.ErrDeadlineExceeded)
+		}
+		return nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (pt *pipelineTransport) Close() error {
+	pt.plock.Lock()
+	pc := pt.pconn
+	pt.pconn = nil
+	pt.plock.Unlock()
+	if pc == nil {
+		return nil
+	}
+	return pc.close(ErrConnectionClosed)
+}
+
+// Allocate a free transaction ID for the waiter
+func (pc *pipelineConn) register(ch chan *modbusResponse) (uint16, error) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	if pc.closed {
+		return 0, ErrConnectionClosed
+	}
+	for {
+		pc.lastTxn++
+		if _, have := pc.waiters[pc.lastTxn]; !have {
+			break
+		}
+	}
+	pc.waiters[pc.lastTxn] = ch
+	return pc.lastTxn, nil
+}
+
+func (pc *pipelineConn) unregister(txnID uint16) chan *modbusResponse {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	ch, have := pc.waiters[txnID]
+	if !have {
+		return nil
+	}
+	delete(pc.waiters, txnID)
+	return ch
+}
+
+func (pc *pipelineConn) touch() {
+	pc.lock.Lock()
+	pc.lastRead = time.Now()
+	pc.lock.Unlock()
+}
+
+func (pc *pipelineConn) lastReadTime() time.Time {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	return pc.lastRead
+}
+
+func (pc *pipelineConn) write(data []byte, timeout time.Duration) error {
+	pc.writeLock.Lock()
+	defer pc.writeLock.Unlock()
+	if timeout > 0 {
+		pc.conn.SetWriteDeadline(time.Now().Add(timeout))
+		defer pc.conn.SetWriteDeadline(time.Time{})
+	}
+	_, err := pc.conn.Write(data)
+	return err
+}
+
+// Close connection and wake up all waiters with error
+func (pc *pipelineConn) close(err error) error {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+	if pc.closed {
+		return nil
+	}
+	pc.closed = true
+	for txnID, ch := range pc.waiters {
+		ch <- &modbusResponse{
+			err: err,
+		}
+		delete(pc.waiters, txnID)
+	}
+	return pc.conn.Close()
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Data of polled range, register is 2 bytes and bit is 1 byte (0 or 1)
+type shadowTable struct {
+	cfg       *config.Poll
+	rng       addressRange
+	data      []byte
+	updatedAt time.Time
+	// Increased by write, poll started before the write is dropped
+	gen  uint64
+	lock sync.RWMutex
+}
+
+func newShadowTable(cfg *config.Poll) *shadowTable {
+	st := &shadowTable{
+		cfg: cfg,
+	}
+	ranges, _ := requestRanges(st.request())
+	st.rng = ranges[0]
+	return st
+}
+
+func (st *shadowTable) request() *pdu {
+	return newReadRequest(uint8(st.cfg.UnitID), uint8(st.cfg.FunctionCode), st.cfg.Address, st.cfg.Count)
+}
+
+func (st *shadowTable) generation() uint64 {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	return st.gen
+}
+
+// Update data by poll response, returns false if response is invalid
+func (st *shadowTable) update(resp *pdu, gen uint64) bool {
+	values, ok := readResponseValues(st.request(), resp, st.cfg.Count)
+	if !ok {
+		return false
+	}
+	st.lock.Lock()
+	if st.gen == gen {
+		st.data = values
+		st.updatedAt = time.Now()
+	}
+	st.lock.Unlock()
+	return true
+}
+
+// Mark data as stale, so reads go to backend until next poll
+func (st *shadowTable) invalidate() {
+	st.lock.Lock()
+	st.gen++
+	st.updatedAt = time.Time{}
+	st.lock.Unlock()
+}
+
+// Returns response for read request inside polled range, returns nil if
+// request is not covered or data is stale.
+func (st *shadowTable) read(req *pdu) *pdu {
+	address, count, ok := readRange(req)
+	if !ok || req.unitID != uint8(st.cfg.UnitID) || req.funcCode != uint8(st.cfg.FunctionCode) {
+		return nil
+	}
+	if count < 1 || address < st.cfg.Address || address+count > st.cfg.Address+st.cfg.Count {
+		return nil
+	}
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+	maxAge := time.Duration(st.cfg.MaxAge) * time.Millisecond
+	if st.updatedAt.IsZero() || time.Since(st.updatedAt) > maxAge {
+		return nil
+	}
+	offset := address - st.cfg.Address
+	if isBitFunction(req.funcCode) {
+		return newReadResponse(req, st.data[offset:offset+count])
+	}
+	return newReadResponse(req, st.data[offset*2:(offset+count)*2])
+}
+
+// Answer read request from shadow tables
+func (b *Backend) readShadow(req *pdu) *pdu {
+	for _, st := range b.shadows {
+		if resp := st.read(req); resp != nil {
+			return resp
+		}
+	}
+	return nil
+}
+
+// Write request makes shadow tables of overlapped ranges stale
+func (b *Backend) invalidateShadow(req *pdu) {
+	if len(b.shadows) == 0 {
+		return
+	}
+	ranges, err := requestRanges(req)
+	if err != nil {
+		return
+	}
+	for _, st := range b.shadows {
+		// Broadcast writes all units
+		if req.unitID != 0 && req.unitID != uint8(st.cfg.UnitID) {
+			continue
+		}
+		for _, rng := range ranges {
+			if rng.overlaps(st.rng) {
+				st.invalidate()
+				break
+			}
+		}
+	}
+}
+
+func (b *Backend) runPoll(st *shadowTable) {
+	interval := time.Duration(st.cfg.Interval) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.poll(st, interval)
+		select {
+		case <-b.stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Backend) poll(st *shadowTable, interval time.Duration) {
+	// Poll request should not wait in queue longer than interval
+	ctx, cancel := context.WithTimeout(context.Background(), interval)
+	defer cancel()
+	gen := st.generation()
+	resp, err := b.executeBreaker(ctx, nil, st.request())
+	if !b.running {
+		return
+	}
+	if err != nil {
+		log.Printf("Poll backend %s got error: %v", b.Name, err)
+		return
+	}
+	if !st.update(resp, gen) {
+		log.Printf("Poll backend %s unit %d address %d got invalid response: %v", b.Name, st.cfg.UnitID, st.cfg.Address, resp)
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// Returns error class name used by retry policy
+func retryErrorClass(err error) string {
+	var nerr net.Error
+	switch {
+	case errors.Is(err, serial.ErrTimeout), errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &nerr) && nerr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.Is(err, ErrBadCRC), errors.Is(err, ErrBadLRC):
+		return "crc"
+	case errors.Is(err, ErrShortFrame), errors.Is(err, ErrProtocolError), errors.Is(err, ErrInvalidProtocol):
+		return "frame"
+	}
+	return ""
+}
+
+func (b *Backend) isRetryable(resp *pdu, err error) bool {
+	policy := b.bcfg.Retry
+	if err != nil {
+		class := retryErrorClass(err)
+		for _, name := range policy.Errors {
+			if name == class {
+				return true
+			}
+		}
+		return false
+	}
+	if resp == nil || resp.funcCode&0x80 == 0 || len(resp.payload) == 0 {
+		return false
+	}
+	for _, code := range policy.Exceptions {
+		if code == int(resp.payload[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backend) retryBackoff(attempt int) time.Duration {
+	policy := b.bcfg.Retry
+	// Exponential backoff: backoff, 2 * backoff, 4 * backoff ...
+	backoff := time.Duration(policy.Backoff) * time.Millisecond
+	for i := 1; i < attempt; i++ {
+		backoff *= 2
+	}
+	maxBackoff := time.Duration(policy.MaxBackoff) * time.Millisecond
+	if maxBackoff > 0 && backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	return backoff
+}
+
+// Execute request on transport with retry policy, write requests will not
+// be retried unless retry writes is enabled.
+func (b *Backend) executeWithRetry(ctx context.Context, trans Transport, req *pdu) (*pdu, error) {
+	policy := b.bcfg.Retry
+	attempts := policy.MaxAttempts
+	if isWriteFunction(req.funcCode) && !policy.RetryWrites {
+		attempts = 1
+	}
+	var (
+		resp *pdu
+		err  error
+	)
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			// Do not retry if request is expired during backoff
+			if !sleepContext(ctx, b.retryBackoff(i)) {
+				break
+			}
+			log.Printf("Retry request to backend %s (attempt %d), last error: %v", b.Name, i+1, err)
+		}
+		resp, err = trans.ExecuteRequest(req)
+		if !b.isRetryable(resp, err) {
+			break
+		}
+	}
+	return resp, err
+}
+
+// Sleep for duration, returns false if context is done before
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/blacktear23/modbus_gateway/config"
 )
@@ -10,6 +12,8 @@ import (
 type Router struct {
 	cfg      *config.Config
 	backends map[string]*Backend
+	groups   map[string]*failoverGroup
+	cache    *readCache
 	lock     sync.RWMutex
 }
 
@@ -17,6 +21,8 @@ func NewRouter(cfg *config.Config) *Router {
 	ret := &Router{
 		cfg:      cfg,
 		backends: map[string]*Backend{},
+		groups:   map[string]*failoverGroup{},
+		cache:    newReadCache(),
 	}
 	ret.init()
 	return ret
@@ -29,8 +35,8 @@ func (r *Router) init() {
 	}
 }
 
-func (r *Router) GetBackendByUnitID(uid uint8) (*Backend, uint8) {
-	umap, back := r.cfg.GetUnitIDMap(uid)
+func (r *Router) GetBackendByUnitID(listener string, uid uint8) (*Backend, uint8) {
+	umap, back := r.cfg.GetUnitIDMap(listener, uid)
 	if umap == nil || back == nil {
 		return nil, 0
 	}
@@ -38,18 +44,38 @@ func (r *Router) GetBackendByUnitID(uid uint8) (*Backend, uint8) {
 	if backend == nil {
 		return nil, 0
 	}
-	return backend, uint8(umap.TargetUnitID)
+	return backend, umap.GetTargetUnitID(uid)
 }
 
-func (r *Router) RequestBackend(uid uint8, req *pdu) (*pdu, error) {
-	backend, tuid := r.GetBackendByUnitID(uid)
-	// No background target
-	if backend == nil {
+// Check the Unit ID is served by gateway for the listener
+func (r *Router) HasUnitID(listener string, uid uint8) bool {
+	umap, back := r.cfg.GetUnitIDMap(listener, uid)
+	return umap != nil && back != nil
+}
+
+// Create context for request, timeout 0 means no deadline
+func newRequestContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func (r *Router) RequestBackend(ctx context.Context, client *clientInfo, uid uint8, req *pdu) (*pdu, error) {
+	if !r.authorize(client, uid, req) {
+		log.Printf("Deny request from %s (identity: %s, role: %s), unit ID: %d, function code: 0x%02x", client.remoteAddr, client.identity, client.role, uid, req.funcCode)
+		return r.respModbusError(uid, req, MErrIllegalFunction), nil
+	}
+	if uid == 0 {
+		return r.requestBroadcast(ctx, client, req)
+	}
+	umap, _ := r.cfg.GetUnitIDMap(client.listener, uid)
+	if umap == nil {
 		return r.respModbusError(uid, req, MErrGWTargetFailedToRespond), nil
 	}
 	// Transform to target unit ID
-	req.unitID = tuid
-	resp, err := backend.ExecuteRequest(req)
+	req.unitID = umap.GetTargetUnitID(uid)
+	resp, err := r.requestCache(ctx, client, umap, req)
 	// Restore unit ID to origin
 	if resp != nil {
 		resp.unitID = uid
@@ -57,6 +83,47 @@ func (r *Router) RequestBackend(uid uint8, req *pdu) (*pdu, error) {
 	return resp, err
 }
 
+// Decide cache TTL of read request by address seen by client. Cached
+// responses are looked up after translation by request sent to backend.
+func (r *Router) requestCache(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu) (*pdu, error) {
+	if umap.Cache != nil && isReadFunction(req.funcCode) {
+		ranges, err := requestRanges(req)
+		if err == nil && len(ranges) > 0 {
+			ttl := umap.Cache.GetTTL(req.funcCode, ranges[0].address, ranges[0].count)
+			ctx = withCacheTTL(ctx, ttl)
+		}
+	}
+	return r.requestUnitMap(ctx, client, umap, req)
+}
+
+func (r *Router) requestUnitMap(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu) (*pdu, error) {
+	if umap.Translate != nil {
+		return r.requestTranslate(ctx, client, umap, req)
+	}
+	return r.routeUnitMap(ctx, client, umap, req)
+}
+
+func (r *Router) routeUnitMap(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu) (*pdu, error) {
+	if len(umap.Ranges) > 0 {
+		return r.requestRange(ctx, client, umap, req)
+	}
+	return r.requestBackends(ctx, client, umap, req)
+}
+
+func (r *Router) requestBackends(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu) (*pdu, error) {
+	return r.cache.request(ctx, umap.Backends, req, func() (*pdu, error) {
+		if len(umap.Backends) > 1 {
+			return r.requestFailover(ctx, client, umap, req)
+		}
+		backend := r.getBackend(umap.Backend)
+		// No background target or backend is down
+		if backend == nil || !backend.IsHealthy() {
+			return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
+		}
+		return backend.ExecuteRequest(ctx, client, req)
+	})
+}
+
 func (r *Router) respModbusError(uid uint8, req *pdu, errCode uint8) *pdu {
 	eresp := &pdu{
 		unitID:   uid,
@@ -106,6 +173,8 @@ func (r *Router) Reload() {
 		}
 	}
 	r.backends = newBackends
+	// Unit map or backend may be changed
+	r.cache.clear()
 }
 
 func (r *Router) Stop() {
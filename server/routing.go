@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Data table names used by unit map ranges
+var tableNames = map[int]string{
+	tableCoils:            "coils",
+	tableDiscreteInputs:   "discrete_inputs",
+	tableHoldingRegisters: "holding_registers",
+	tableInputRegisters:   "input_registers",
+}
+
+// Returns positions of address fields in request payload
+func addressPositions(funcCode uint8) []int {
+	switch funcCode {
+	case FCReadCoils,
+		FCReadDiscreteInputs,
+		FCReadHoldingRegisters,
+		FCReadInputRegisters,
+		FCWriteSingleCoil,
+		FCWriteSingleRegister,
+		FCWriteMultipleCoils,
+		FCWriteMultipleRegisters,
+		FCMaskWriteRegister,
+		FCReadFifoQueue:
+		return []int{0}
+	case FCReadWriteMultipleRegisters:
+		return []int{0, 4}
+	}
+	return nil
+}
+
+func translateAddress(payload []byte, positions []int, delta int) {
+	for _, pos := range positions {
+		if len(payload) < pos+2 {
+			continue
+		}
+		address := int(bytesToUint16(BIG_ENDIAN, payload[pos:pos+2])) + delta
+		copy(payload[pos:pos+2], uint16ToBytes(BIG_ENDIAN, uint16(address)))
+	}
+}
+
+// Returns copy of request with address fields added by delta
+func translateRequestAddress(req *pdu, delta int) *pdu {
+	ret := copyPdu(req)
+	translateAddress(ret.payload, addressPositions(req.funcCode), delta)
+	return ret
+}
+
+// Write responses echo request address, add delta to it
+func translateResponseAddress(resp *pdu, delta int) {
+	switch resp.funcCode {
+	case FCWriteSingleCoil,
+		FCWriteSingleRegister,
+		FCWriteMultipleCoils,
+		FCWriteMultipleRegisters,
+		FCMaskWriteRegister:
+		translateAddress(resp.payload, []int{0}, delta)
+	}
+}
+
+// Route request by address to unit map range. Request not in any range is
+// sent to default backends of unit map, request crosses ranges is rejected
+// unless it is a read and aggregate is enabled.
+func (r *Router) requestRange(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu) (*pdu, error) {
+	ranges, err := requestRanges(req)
+	if err != nil {
+		return modbusErrorPdu(req, MErrIllegalDataValue), nil
+	}
+	var route *config.UnitMapRange
+	for i, rng := range ranges {
+		rr, err := umap.GetRange(tableNames[rng.table], rng.address, rng.count)
+		// Read crosses ranges is split to parts if aggregate is enabled
+		if err == config.ErrCrossRanges && umap.Aggregate && isReadFunction(req.funcCode) {
+			return r.requestAggregate(ctx, client, umap, req, rng)
+		}
+		if err != nil || (i > 0 && rr != route) {
+			return modbusErrorPdu(req, MErrIllegalDataAddress), nil
+		}
+		route = rr
+	}
+	if route == nil {
+		if len(umap.Backends) > 0 {
+			return r.requestBackends(ctx, client, umap, req)
+		}
+		if len(ranges) == 0 {
+			return modbusErrorPdu(req, MErrIllegalFunction), nil
+		}
+		return modbusErrorPdu(req, MErrIllegalDataAddress), nil
+	}
+	treq := translateRequestAddress(req, route.Offset)
+	if route.TargetUnitID != 0 {
+		treq.unitID = uint8(route.TargetUnitID)
+	}
+	resp, err := r.requestBackends(ctx, client, route.UnitMap(), treq)
+	if resp != nil {
+		translateResponseAddress(resp, -route.Offset)
+	}
+	return resp, err
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+const (
+	// Connection is silent for this time means request is complete
+	rtuRequestSilence = 50 * time.Millisecond
+)
+
+// RTU over TCP server, receive raw RTU ADU (with CRC) from TCP connection.
+type RTUOverTCPServer struct {
+	*TCPServer
+}
+
+func NewRTUOverTCPServer(cfg *config.Listener, timeout int, router *Router) *RTUOverTCPServer {
+	s := &RTUOverTCPServer{
+		TCPServer: NewTCPServer(cfg, timeout, router),
+	}
+	s.handler = s.handleRTUConn
+	return s
+}
+
+func (s *RTUOverTCPServer) handleRTUConn(conn net.Conn) {
+	defer conn.Close()
+	client, err := s.newClientInfo(conn)
+	if err != nil {
+		log.Println("TLS handshake got error:", err)
+		return
+	}
+	for {
+		// Read the request
+		req, err := readRTURequest(conn)
+		if err == ErrIllegalFunction {
+			// Unknown request length, drop rest of request and answer
+			// exception instead of closing the connection
+			discardRequest(conn)
+			_, err = conn.Write(encodeRTUFrame(modbusErrorPdu(req, MErrIllegalFunction)))
+			if err != nil {
+				log.Println("Write response got error:", err)
+				break
+			}
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && s.running {
+				log.Println("Read RTU request got error:", err)
+			}
+			// RTU frame has no length field, cannot re-sync
+			// stream so just close the connection
+			break
+		}
+		// Route to backend
+		uid := req.unitID
+		resp, err := s.routeRequest(client, uid, req)
+		if err != nil {
+			log.Println("Get response got error:", err)
+		}
+		if resp == nil {
+			break
+		}
+		// Response should use unit ID from request
+		resp.unitID = uid
+		_, err = conn.Write(encodeRTUFrame(resp))
+		if err != nil {
+			log.Println("Write response got error:", err)
+			break
+		}
+	}
+}
+
+// Read one RTU request frame (with CRC) from the reader.
+func readRTURequest(r io.Reader) (*pdu, error) {
+	buf := make([]byte, maxRTUFrameLength)
+
+	// Unit ID and function code
+	_, err := io.ReadFull(r, buf[0:2])
+	if err != nil {
+		return nil, err
+	}
+
+	fixedBytes, countPos, err := calculateRequestBytes(buf[1])
+	if err == ErrIllegalFunction {
+		return &pdu{unitID: buf[0], funcCode: buf[1]}, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	pos := 2
+	_, err = io.ReadFull(r, buf[pos:pos+fixedBytes])
+	if err != nil {
+		return nil, err
+	}
+	pos += fixedBytes
+
+	restBytes := 0
+	if countPos >= 0 {
+		restBytes = int(buf[2+countPos])
+	}
+	// Add for CRC
+	restBytes += 2
+	if pos+restBytes > maxRTUFrameLength {
+		return nil, ErrProtocolError
+	}
+
+	_, err = io.ReadFull(r, buf[pos:pos+restBytes])
+	if err != nil {
+		return nil, err
+	}
+	pos += restBytes
+	return decodeRTUFrame(buf[0:pos])
+}
+
+// Drop rest of request with unknown length. Client waits for response, so
+// request is complete when connection is silent.
+func discardRequest(conn net.Conn) {
+	buf := make([]byte, maxRTUFrameLength)
+	for {
+		conn.SetReadDeadline(time.Now().Add(rtuRequestSilence))
+		if _, err := conn.Read(buf); err != nil {
+			break
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadRTURequest(t *testing.T) {
+	valid := []*pdu{
+		{unitID: 1, funcCode: FCReadHoldingRegisters, payload: []byte{0x00, 0x6b, 0x00, 0x03}},
+		{unitID: 2, funcCode: FCWriteSingleCoil, payload: []byte{0x00, 0xac, 0xff, 0x00}},
+		{unitID: 3, funcCode: FCWriteMultipleRegisters, payload: []byte{0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0a, 0x01, 0x02}},
+		{unitID: 4, funcCode: FCWriteMultipleCoils, payload: []byte{0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01}},
+		{unitID: 5, funcCode: FCMaskWriteRegister, payload: []byte{0x00, 0x04, 0x00, 0xf2, 0x00, 0x25}},
+		{unitID: 6, funcCode: FCReadWriteMultipleRegisters, payload: []byte{0x00, 0x03, 0x00, 0x06, 0x00, 0x0e, 0x00, 0x01, 0x02, 0x00, 0xff}},
+		{unitID: 7, funcCode: FCReadFifoQueue, payload: []byte{0x04, 0xde}},
+		{unitID: 8, funcCode: FCReadFileRecord, payload: []byte{0x07, 0x06, 0x00, 0x04, 0x00, 0x01, 0x00, 0x02}},
+		{unitID: 9, funcCode: FCReadExceptionStatus, payload: []byte{}},
+		{unitID: 10, funcCode: FCDiagnostics, payload: []byte{0x00, 0x00, 0xa5, 0x37}},
+		{unitID: 11, funcCode: FCReportServerID, payload: []byte{}},
+		{unitID: 12, funcCode: FCEncapsulatedInterface, payload: []byte{0x0e, 0x01, 0x00}},
+	}
+	for _, req := range valid {
+		frame := encodeRTUFrame(req)
+		// Next request in stream should not be consumed
+		r := bytes.NewReader(append(frame, 0x01))
+		got, err := readRTURequest(r)
+		if err != nil {
+			t.Errorf("read % x got error: %v", frame, err)
+			continue
+		}
+		if !equalPdu(got, req) {
+			t.Errorf("read % x = %+v, want %+v", frame, got, req)
+		}
+		if r.Len() != 1 {
+			t.Errorf("read % x left %d bytes, want 1", frame, r.Len())
+		}
+	}
+
+	badCRC := encodeRTUFrame(valid[0])
+	badCRC[len(badCRC)-1] ^= 0xff
+	tooLong := encodeRTUFrame(&pdu{unitID: 1, funcCode: FCWriteMultipleRegisters, payload: append([]byte{0x00, 0x00, 0x00, 0x7f, 0xfe}, make([]byte, 0xfe)...)})
+	tests := []struct {
+		name  string
+		input []byte
+		err   error
+	}{
+		{"bad CRC", badCRC, ErrBadCRC},
+		{"too long", tooLong, ErrProtocolError},
+		{"empty", []byte{}, io.EOF},
+		{"truncated header", []byte{0x01}, io.ErrUnexpectedEOF},
+		{"truncated payload", encodeRTUFrame(valid[0])[0:5], io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		_, err := readRTURequest(bytes.NewReader(tt.input))
+		if err != tt.err {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestReadRTURequestUnknownFunction(t *testing.T) {
+	// Length of unknown function code is not known, unit ID and function
+	// code are returned to answer exception
+	req, err := readRTURequest(bytes.NewReader([]byte{0x05, 0x41, 0x01, 0x02}))
+	if err != ErrIllegalFunction {
+		t.Fatalf("got error %v, want %v", err, ErrIllegalFunction)
+	}
+	if req == nil || req.unitID != 0x05 || req.funcCode != 0x41 {
+		t.Fatalf("got request %+v, want unit 5 function 0x41", req)
+	}
+}
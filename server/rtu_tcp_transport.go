@@ -0,0 +1,69 @@
+package server
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// RTU over TCP transport, send raw RTU ADU (with CRC) through TCP
+// connection, it is used for serial device servers.
+type rtuTcpTransport struct {
+	*tcpTransport
+}
+
+func newRtuTcpTransport(cfg *config.Backend) *rtuTcpTransport {
+	return &rtuTcpTransport{
+		tcpTransport: newTcpTransport(cfg),
+	}
+}
+
+func (rt *rtuTcpTransport) ExecuteRequest(req *pdu) (*pdu, error) {
+	if err := rt.ensureConn(); err != nil {
+		log.Println("Connect backend got error:", err)
+		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
+	}
+	resp, err := rt.executeRequestRTU(req)
+	if err != nil {
+		return modbusErrorPdu(req, MErrGWPathUnavailable), err
+	}
+	return resp, err
+}
+
+func (rt *rtuTcpTransport) executeRequestRTU(req *pdu) (*pdu, error) {
+	var err error
+	if rt.timeout > 0 {
+		err = rt.conn.SetDeadline(time.Now().Add(rt.timeout))
+		if err != nil {
+			return nil, err
+		}
+		defer func(conn net.Conn) {
+			conn.SetDeadline(time.Time{})
+		}(rt.conn)
+	}
+	_, err = rt.conn.Write(encodeRTUFrame(req))
+	if err != nil {
+		rt.cleanErrorConn()
+		return nil, err
+	}
+	// No response for broadcast, let devices process it
+	if req.unitID == 0 {
+		time.Sleep(time.Duration(rt.cfg.TurnaroundDelay) * time.Millisecond)
+		return broadcastResponse(req), nil
+	}
+	resp, err := readRTUFrame(rt.conn)
+	if err != nil {
+		// RTU frame has no transaction ID, so stream may contains
+		// partial frame. Drop the connection to re-sync.
+		rt.cleanErrorConn()
+		return nil, err
+	}
+	if resp.unitID != req.unitID {
+		// Response for another request, connection is out of sync
+		rt.cleanErrorConn()
+		return nil, ErrProtocolError
+	}
+	return resp, nil
+}
@@ -19,20 +19,17 @@ type serialTransport struct {
 	lastActivity time.Time
 	t35          time.Duration
 	t1           time.Duration
+	turnaround   time.Duration
 	lock         sync.RWMutex
 }
 
 func newSerialTransport(cfg *config.Backend) *serialTransport {
-	var t35 time.Duration
-	if cfg.Baudrate >= 19200 {
-		t35 = 1750 * time.Microsecond
-	} else {
-		t35 = (serialCharTime(cfg.Baudrate) * 35) / 10
-	}
 	return &serialTransport{
 		cfg: cfg,
 		t1:  serialCharTime(cfg.Baudrate),
-		t35: t35,
+		t35: serialFrameDelay(cfg.Baudrate),
+		// Wait time after broadcast
+		turnaround: time.Duration(cfg.TurnaroundDelay) * time.Millisecond,
 	}
 }
 
@@ -43,7 +40,7 @@ func (st *serialTransport) ensureConn() error {
 		return nil
 	}
 	st.lock.RUnlock()
-	conn := newSerialConn(st.cfg)
+	conn := newSerialConn(st.cfg.Address, &st.cfg.SerialOptions, time.Duration(st.cfg.Timeout)*time.Millisecond)
 	err := conn.Open()
 	if err != nil {
 		return err
@@ -78,7 +75,7 @@ func (st *serialTransport) executeRequestRTU(req *pdu) (*pdu, error) {
 	ts := time.Now()
 	// build an RTU ADU out of the request object and
 	// send the final ADU+CRC on the wire
-	n, err := st.conn.Write(st.encodeRTUFrame(req))
+	n, err := st.conn.Write(encodeRTUFrame(req))
 	if err != nil {
 		return modbusErrorPdu(req, MErrGWPathUnavailable), err
 	}
@@ -91,8 +88,15 @@ func (st *serialTransport) executeRequestRTU(req *pdu) (*pdu, error) {
 	// observe inter-frame delays
 	time.Sleep(st.lastActivity.Add(st.t35).Sub(time.Now()))
 
+	// no response for broadcast, let devices process it
+	if req.unitID == 0 {
+		time.Sleep(st.turnaround)
+		st.lastActivity = time.Now()
+		return broadcastResponse(req), nil
+	}
+
 	// read the response back from the wire
-	resp, err := st.readRTUFrame()
+	resp, err := readRTUFrame(st.conn)
 
 	if err == ErrBadCRC || err == ErrProtocolError || err == ErrShortFrame {
 		// wait for and flush any data coming off the link to allow
@@ -111,10 +115,11 @@ func (st *serialTransport) executeRequestRTU(req *pdu) (*pdu, error) {
 	return resp, err
 }
 
-func (st *serialTransport) readRTUFrame() (*pdu, error) {
+// Read one RTU response frame (with CRC) from the reader.
+func readRTUFrame(r io.Reader) (*pdu, error) {
 	buf := make([]byte, maxRTUFrameLength)
 
-	n, err := io.ReadFull(st.conn, buf[0:3])
+	n, err := io.ReadFull(r, buf[0:3])
 	if (n > 0 || err == nil) && n != 3 {
 		return nil, ErrShortFrame
 	}
@@ -128,7 +133,7 @@ func (st *serialTransport) readRTUFrame() (*pdu, error) {
 	if err != nil {
 		if err == ErrNeedReadMore {
 			// Read one more byte
-			n, err := io.ReadFull(st.conn, buf[3:4])
+			n, err := io.ReadFull(r, buf[3:4])
 			if (n > 0 || err == nil) && n != 1 {
 				return nil, ErrShortFrame
 			}
@@ -148,7 +153,7 @@ func (st *serialTransport) readRTUFrame() (*pdu, error) {
 		return nil, ErrProtocolError
 	}
 
-	n, err = io.ReadFull(st.conn, buf[startPos:startPos+restBytes])
+	n, err = io.ReadFull(r, buf[startPos:startPos+restBytes])
 	if err != nil && err != io.ErrUnexpectedEOF {
 		return nil, err
 	}
@@ -171,7 +176,8 @@ func (st *serialTransport) readRTUFrame() (*pdu, error) {
 	return resp, nil
 }
 
-func (st *serialTransport) encodeRTUFrame(req *pdu) []byte {
+// Encode PDU to RTU ADU with CRC.
+func encodeRTUFrame(req *pdu) []byte {
 	var (
 		crc crc
 		adu []byte
@@ -186,6 +192,28 @@ func (st *serialTransport) encodeRTUFrame(req *pdu) []byte {
 	return adu
 }
 
+// Decode RTU ADU and check CRC.
+func decodeRTUFrame(adu []byte) (*pdu, error) {
+	if len(adu) < 4 {
+		return nil, ErrShortFrame
+	}
+	if len(adu) > maxRTUFrameLength {
+		return nil, ErrProtocolError
+	}
+	var crc crc
+	crc.init()
+	crc.add(adu[0 : len(adu)-2])
+	if !crc.isEqual(adu[len(adu)-2], adu[len(adu)-1]) {
+		return nil, ErrBadCRC
+	}
+	ret := &pdu{
+		unitID:   adu[0],
+		funcCode: adu[1],
+		payload:  adu[2 : len(adu)-2],
+	}
+	return ret, nil
+}
+
 func (st *serialTransport) Close() error {
 	st.lock.Lock()
 	defer st.lock.Unlock()
@@ -209,6 +237,15 @@ func serialCharTime(rate_bps int) (ct time.Duration) {
 	return
 }
 
+// Returns the t3.5 inter-frame delay at the specified baud rate.
+func serialFrameDelay(rate_bps int) time.Duration {
+	// Spec recommends fixed 1.75ms when baud rate is greater than 19200
+	if rate_bps >= 19200 {
+		return 1750 * time.Microsecond
+	}
+	return (serialCharTime(rate_bps) * 35) / 10
+}
+
 // Discards the contents of the link's rx buffer, eating up to 1kB of data.
 // Note that on a serial line, this call may block for up to serialConf.Timeout
 // i.e. 10ms.
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+const (
+	priorityWrite = iota
+	priorityHigh
+	priorityNormal
+	numPriorities
+)
+
+// Dispatch queued requests to backend workers. Requests from one client are
+// kept in FIFO order, the priority of a client is decided by its first queued
+// request and clients in same priority are served in round robin. Without
+// scheduler config all requests are in one FIFO queue.
+type scheduler struct {
+	cfg *config.Scheduler
+	// Limit of queued requests
+	slots  chan struct{}
+	lock   sync.Mutex
+	cond   *sync.Cond
+	count  int
+	closed bool
+	queues map[string][]*modbusRequest
+	// Clients have queued requests in round robin order for each priority
+	rounds [numPriorities][]string
+}
+
+func newScheduler(cfg *config.Scheduler, size int) *scheduler {
+	s := &scheduler{
+		cfg:    cfg,
+		slots:  make(chan struct{}, size),
+		queues: map[string][]*modbusRequest{},
+	}
+	s.cond = sync.NewCond(&s.lock)
+	return s
+}
+
+func (s *scheduler) priority(req *modbusRequest) int {
+	if s.cfg == nil {
+		return priorityNormal
+	}
+	if isWriteFunction(req.req.funcCode) {
+		return priorityWrite
+	}
+	return s.clientPriority(req.client)
+}
+
+// Priority of read request from the client
+func (s *scheduler) clientPriority(client *clientInfo) int {
+	if s.cfg != nil && client != nil && s.cfg.IsHighPriorityClient(clientIP(client), client.identity) {
+		return priorityHigh
+	}
+	return priorityNormal
+}
+
+// Requests are fair queued by client identity or client host
+func (s *scheduler) clientKey(req *modbusRequest) string {
+	if s.cfg == nil || req.client == nil {
+		return ""
+	}
+	if req.client.identity != "" {
+		return req.client.identity
+	}
+	host, _, err := net.SplitHostPort(req.client.remoteAddr)
+	if err != nil {
+		return req.client.remoteAddr
+	}
+	return host
+}
+
+// Put request into queue. If nowait is true returns ErrQueueFull when queue
+// is full, else wait for free slot no longer than timeout (0 means forever)
+// and request deadline. It panics or returns ErrClientClosed if scheduler is
+// closed.
+func (s *scheduler) push(req *modbusRequest, nowait bool, timeout time.Duration) error {
+	if nowait {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			return ErrQueueFull
+		}
+	} else {
+		var timeoutCh <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			timeoutCh = timer.C
+		}
+		select {
+		case s.slots <- struct{}{}:
+		case <-timeoutCh:
+			return ErrQueueTimeout
+		case <-req.ctx.Done():
+			return req.ctx.Err()
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClientClosed
+	}
+	key := s.clientKey(req)
+	queue := s.queues[key]
+	if len(queue) == 0 {
+		prio := s.priority(req)
+		s.rounds[prio] = append(s.rounds[prio], key)
+	}
+	s.queues[key] = append(queue, req)
+	s.count++
+	s.cond.Signal()
+	return nil
+}
+
+// Wait for next request, returns false if scheduler is closed and no
+// request left in queue
+func (s *scheduler) next() (*modbusRequest, bool) {
+	s.lock.Lock()
+	for s.count == 0 && !s.closed {
+		s.cond.Wait()
+	}
+	if s.count == 0 {
+		s.lock.Unlock()
+		return nil, false
+	}
+	req := s.pop()
+	s.count--
+	s.lock.Unlock()
+	<-s.slots
+	return req, true
+}
+
+// Take queued requests accepted by function, it is called for requests until
+// no more request is accepted. Only reads before any other request of the
+// client can be taken, so order between reads and writes of one client is kept.
+// Clients are visited in dispatch order and keep their round robin position.
+func (s *scheduler) take(accept func(req *modbusRequest) bool) []*modbusRequest {
+	var ret []*modbusRequest
+	s.lock.Lock()
+	for changed := true; changed; {
+		changed = false
+		for prio := range s.rounds {
+			// Round may be changed by requeue
+			keys := append([]string(nil), s.rounds[prio]...)
+			for _, key := range keys {
+				queue := s.queues[key]
+				taken := false
+				for i := 0; i < len(queue); i++ {
+					if !isReadFunction(queue[i].req.funcCode) {
+						break
+					}
+					if !accept(queue[i]) {
+						continue
+					}
+					ret = append(ret, queue[i])
+					queue = append(queue[:i], queue[i+1:]...)
+					i--
+					taken = true
+				}
+				if taken {
+					s.requeue(prio, key, queue)
+					changed = true
+				}
+			}
+		}
+	}
+	s.count -= len(ret)
+	s.lock.Unlock()
+	for range ret {
+		<-s.slots
+	}
+	return ret
+}
+
+// Update client queue after requests are taken, client is moved to tail of
+// another round only if priority of its first request is changed
+func (s *scheduler) requeue(prio int, key string, queue []*modbusRequest) {
+	next := -1
+	if len(queue) == 0 {
+		delete(s.queues, key)
+	} else {
+		s.queues[key] = queue
+		next = s.priority(queue[0])
+	}
+	if next == prio {
+		return
+	}
+	for i, k := range s.rounds[prio] {
+		if k == key {
+			s.rounds[prio] = append(s.rounds[prio][:i], s.rounds[prio][i+1:]...)
+			break
+		}
+	}
+	if next >= 0 {
+		s.rounds[next] = append(s.rounds[next], key)
+	}
+}
+
+// Pop next request, lock should be held
+func (s *scheduler) pop() *modbusRequest {
+	for prio := range s.rounds {
+		if len(s.rounds[prio]) == 0 {
+			continue
+		}
+		key := s.rounds[prio][0]
+		s.rounds[prio] = s.rounds[prio][1:]
+		queue := s.queues[key]
+		req := queue[0]
+		queue[0] = nil
+		if len(queue) == 1 {
+			delete(s.queues, key)
+			return req
+		}
+		// Client still has requests, re-queue it by next request
+		queue = queue[1:]
+		s.queues[key] = queue
+		next := s.priority(queue[0])
+		s.rounds[next] = append(s.rounds[next], key)
+		return req
+	}
+	return nil
+}
+
+func (s *scheduler) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	close(s.slots)
+	s.cond.Broadcast()
+}
+
+func clientIP(client *clientInfo) net.IP {
+	host, _, err := net.SplitHostPort(client.remoteAddr)
+	if err != nil {
+		host = client.remoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+var (
+	schedNormalA = &clientInfo{remoteAddr: "10.0.0.2:1000"}
+	schedNormalB = &clientInfo{remoteAddr: "10.0.0.3:1000"}
+	schedNormalC = &clientInfo{remoteAddr: "10.0.0.4:1000"}
+	schedNormalD = &clientInfo{remoteAddr: "10.0.0.5:1000"}
+	schedHighIP  = &clientInfo{remoteAddr: "10.0.0.1:5000"}
+	schedHighID  = &clientInfo{remoteAddr: "10.0.0.9:1000", identity: "hmi"}
+)
+
+type schedPush struct {
+	client   *clientInfo
+	funcCode uint8
+	tag      byte
+}
+
+// Tag is stored in address low byte to identify request
+func newSchedRequest(p schedPush) *modbusRequest {
+	return &modbusRequest{
+		ctx:    context.Background(),
+		client: p.client,
+		req:    &pdu{unitID: 1, funcCode: p.funcCode, payload: []byte{0x00, p.tag, 0x00, 0x01}},
+	}
+}
+
+func schedTag(req *modbusRequest) byte {
+	return req.req.payload[1]
+}
+
+func newTestScheduler(t *testing.T, clients []string, pushes []schedPush) *scheduler {
+	var cfg *config.Scheduler
+	if clients != nil {
+		cfg = &config.Scheduler{HighPriorityClients: clients}
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newScheduler(cfg, 16)
+	for _, p := range pushes {
+		if err := s.push(newSchedRequest(p), true, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+// Pop all queued requests
+func drainScheduler(s *scheduler) []byte {
+	var ret []byte
+	for s.count > 0 {
+		req, ok := s.next()
+		if !ok {
+			break
+		}
+		ret = append(ret, schedTag(req))
+	}
+	return ret
+}
+
+func TestSchedulerOrder(t *testing.T) {
+	pushes := []schedPush{
+		{schedNormalA, FCReadHoldingRegisters, 1},
+		{schedNormalA, FCReadHoldingRegisters, 2},
+		{schedNormalB, FCReadInputRegisters, 3},
+		{schedHighIP, FCReadCoils, 4},
+		{schedNormalA, FCWriteSingleRegister, 5},
+		{schedNormalB, FCReadHoldingRegisters, 6},
+		{schedHighID, FCReadHoldingRegisters, 7},
+		{schedNormalB, FCWriteMultipleRegisters, 8},
+	}
+	tests := []struct {
+		name    string
+		clients []string
+		pushes  []schedPush
+		order   []byte
+	}{
+		{"without config", nil, pushes, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		// High priority first, then normal clients in round robin, client
+		// becomes write priority when write is its first queued request
+		{"priority", []string{"10.0.0.1", "hmi"}, pushes, []byte{4, 7, 1, 3, 2, 5, 6, 8}},
+		{"high priority CIDR", []string{"10.0.0.0/30"}, pushes, []byte{1, 3, 4, 2, 5, 6, 8, 7}},
+		{"round robin", []string{}, []schedPush{
+			{schedNormalA, FCReadCoils, 1},
+			{schedNormalA, FCReadCoils, 2},
+			{schedNormalA, FCReadCoils, 3},
+			{schedNormalB, FCReadCoils, 4},
+			{schedNormalB, FCReadCoils, 5},
+		}, []byte{1, 4, 2, 5, 3}},
+		{"write first", []string{}, []schedPush{
+			{schedNormalA, FCReadCoils, 1},
+			{schedNormalB, FCWriteSingleCoil, 2},
+			{schedNormalB, FCReadCoils, 3},
+			{schedNormalA, FCWriteSingleCoil, 4},
+		}, []byte{2, 1, 4, 3}},
+	}
+	for _, tt := range tests {
+		s := newTestScheduler(t, tt.clients, tt.pushes)
+		if got := drainScheduler(s); string(got) != string(tt.order) {
+			t.Errorf("%s: got order %v, want %v", tt.name, got, tt.order)
+		}
+	}
+}
+
+func TestSchedulerTake(t *testing.T) {
+	acceptAll := func(req *modbusRequest) bool { return true }
+	acceptHolding := func(req *modbusRequest) bool {
+		return req.req.funcCode == FCReadHoldingRegisters
+	}
+	tests := []struct {
+		name   string
+		pushes []schedPush
+		accept func(req *modbusRequest) bool
+		taken  []byte
+		order  []byte
+	}{
+		{"reads", []schedPush{
+			{schedNormalA, FCReadHoldingRegisters, 1},
+			{schedNormalB, FCReadCoils, 2},
+			{schedNormalA, FCReadInputRegisters, 3},
+		}, acceptAll, []byte{1, 3, 2}, nil},
+		// Reads after a write of same client have to wait for the write
+		{"stop at write", []schedPush{
+			{schedNormalA, FCReadHoldingRegisters, 1},
+			{schedNormalA, FCWriteSingleRegister, 2},
+			{schedNormalA, FCReadHoldingRegisters, 3},
+			{schedNormalB, FCReadHoldingRegisters, 4},
+		}, acceptAll, []byte{1, 4}, []byte{2, 3}},
+		{"skip not accepted", []schedPush{
+			{schedNormalA, FCReadCoils, 1},
+			{schedNormalA, FCReadHoldingRegisters, 2},
+			{schedNormalB, FCReadHoldingRegisters, 3},
+			{schedNormalB, FCReadCoils, 4},
+			{schedNormalB, FCReadHoldingRegisters, 5},
+		}, acceptHolding, []byte{2, 3, 5}, []byte{1, 4}},
+		// Clients keep round robin position after take
+		{"keep order", []schedPush{
+			{schedNormalA, FCReadHoldingRegisters, 1},
+			{schedNormalB, FCReadHoldingRegisters, 2},
+			{schedNormalC, FCReadHoldingRegisters, 3},
+			{schedNormalD, FCReadHoldingRegisters, 4},
+			{schedNormalA, FCReadCoils, 5},
+			{schedNormalB, FCReadCoils, 6},
+			{schedNormalC, FCReadCoils, 7},
+			{schedNormalD, FCReadCoils, 8},
+		}, acceptHolding, []byte{1, 2, 3, 4}, []byte{5, 6, 7, 8}},
+		// Client becomes write priority when write is its first request
+		{"priority changed", []schedPush{
+			{schedNormalA, FCReadCoils, 1},
+			{schedNormalB, FCReadHoldingRegisters, 2},
+			{schedNormalB, FCWriteSingleRegister, 3},
+			{schedNormalC, FCReadCoils, 4},
+		}, acceptHolding, []byte{2}, []byte{3, 1, 4}},
+		{"write first", []schedPush{
+			{schedNormalA, FCWriteSingleCoil, 1},
+			{schedNormalA, FCReadCoils, 2},
+		}, acceptAll, nil, []byte{1, 2}},
+	}
+	for _, tt := range tests {
+		s := newTestScheduler(t, []string{}, tt.pushes)
+		var taken []byte
+		for _, req := range s.take(tt.accept) {
+			taken = append(taken, schedTag(req))
+		}
+		if string(taken) != string(tt.taken) {
+			t.Errorf("%s: got taken %v, want %v", tt.name, taken, tt.taken)
+		}
+		if s.count != len(tt.order) || len(s.slots) != len(tt.order) {
+			t.Errorf("%s: got %d queued and %d slots, want %d", tt.name, s.count, len(s.slots), len(tt.order))
+		}
+		if got := drainScheduler(s); string(got) != string(tt.order) {
+			t.Errorf("%s: got order %v, want %v", tt.name, got, tt.order)
+		}
+	}
+}
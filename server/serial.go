@@ -8,25 +8,29 @@ import (
 )
 
 type SerialConn struct {
-	cfg  *config.Backend
-	port serial.Port
+	address string
+	cfg     *config.SerialOptions
+	timeout time.Duration
+	port    serial.Port
 }
 
-func newSerialConn(cfg *config.Backend) *SerialConn {
+func newSerialConn(address string, cfg *config.SerialOptions, timeout time.Duration) *SerialConn {
 	return &SerialConn{
-		cfg: cfg,
+		address: address,
+		cfg:     cfg,
+		timeout: timeout,
 	}
 }
 
 func (c *SerialConn) Open() error {
 	var err error
 	c.port, err = serial.Open(&serial.Config{
-		Address:  c.cfg.Address,
+		Address:  c.address,
 		BaudRate: c.cfg.Baudrate,
 		DataBits: c.cfg.Databits,
 		StopBits: c.cfg.Stopbits,
 		Parity:   c.cfg.Parity,
-		Timeout:  time.Duration(c.cfg.Timeout) * time.Millisecond,
+		Timeout:  c.timeout,
 	})
 	if err != nil {
 		return err
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
+	"github.com/goburrow/serial"
+)
+
+// Serial slave server (RTU or ASCII framing), gateway act as devices on
+// RS-485 bus and answer requests only for Unit IDs configured in unit map.
+type SerialServer struct {
+	running bool
+	cfg     *config.Listener
+	router  *Router
+	conn    *SerialConn
+	t35     time.Duration
+	timeout time.Duration
+}
+
+func NewSerialServer(cfg *config.Listener, timeout int, router *Router) *SerialServer {
+	return &SerialServer{
+		cfg:     cfg,
+		router:  router,
+		t35:     serialFrameDelay(cfg.Baudrate),
+		timeout: time.Duration(timeout) * time.Millisecond,
+	}
+}
+
+func (s *SerialServer) Start() error {
+	// RTU: Read timeout is t3.5, so read timeout means frame is finished
+	// ASCII: Read timeout is 1 second inter-character timeout
+	timeout := s.t35
+	if s.cfg.Framing == "ascii" {
+		timeout = time.Second
+	}
+	s.conn = newSerialConn(s.cfg.Address, &s.cfg.SerialOptions, timeout)
+	err := s.conn.Open()
+	if err != nil {
+		return err
+	}
+	s.running = true
+	go s.run()
+	return nil
+}
+
+func (s *SerialServer) Stop() error {
+	s.running = false
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+func (s *SerialServer) run() {
+	for s.running {
+		req, err := s.readRequest()
+		switch err {
+		case nil:
+			s.handleRequest(req)
+		case serial.ErrTimeout:
+			// Line is idle
+		case ErrProtocolError, ErrShortFrame, ErrBadCRC, ErrBadLRC:
+			log.Println("Decode request got error:", err)
+		default:
+			if s.running {
+				log.Println("Read serial frame got error:", err)
+				// Avoid busy loop when serial port is broken
+				time.Sleep(time.Second)
+			}
+		}
+	}
+}
+
+func (s *SerialServer) readRequest() (*pdu, error) {
+	if s.cfg.Framing == "ascii" {
+		return readASCIIFrame(s.conn)
+	}
+	frame, err := s.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	return decodeRTUFrame(frame)
+}
+
+func (s *SerialServer) encodeResponse(resp *pdu) []byte {
+	if s.cfg.Framing == "ascii" {
+		return encodeASCIIFrame(resp)
+	}
+	return encodeRTUFrame(resp)
+}
+
+func (s *SerialServer) handleRequest(req *pdu) {
+	uid := req.unitID
+	// Broadcast is never answered on serial line
+	broadcast := uid == 0
+	// Other devices on the bus will answer this request
+	if !broadcast && !s.router.HasUnitID(s.cfg.Name, uid) {
+		return
+	}
+	client := &clientInfo{
+		listener:   s.cfg.Name,
+		remoteAddr: s.cfg.Address,
+	}
+	ctx, cancel := newRequestContext(s.timeout)
+	defer cancel()
+	resp, err := s.router.RequestBackend(ctx, client, uid, req)
+	if err != nil {
+		log.Println("Get response got error:", err)
+	}
+	if resp == nil || broadcast {
+		return
+	}
+	resp.unitID = uid
+	_, err = s.conn.Write(s.encodeResponse(resp))
+	if err != nil {
+		log.Println("Write response got error:", err)
+	}
+}
+
+// Read bytes until the line is silent for t3.5
+func (s *SerialServer) readFrame() ([]byte, error) {
+	var (
+		frame    []byte
+		overflow bool
+	)
+	buf := make([]byte, maxRTUFrameLength)
+	for s.running {
+		n, err := s.conn.Read(buf)
+		if err == serial.ErrTimeout {
+			if len(frame) == 0 && !overflow {
+				continue
+			}
+			if overflow {
+				return nil, ErrProtocolError
+			}
+			return frame, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if overflow {
+			continue
+		}
+		frame = append(frame, buf[0:n]...)
+		if len(frame) > maxRTUFrameLength {
+			// Too long for an RTU frame, drop bytes until
+			// the line is silent
+			overflow = true
+			frame = nil
+		}
+	}
+	return nil, ErrClientClosed
+}
@@ -1,11 +1,15 @@
 package server
 
 import (
+	"crypto/tls"
 	"errors"
 	"io"
 	"log"
 	"net"
+	"sync"
 	"time"
+
+	"github.com/blacktear23/modbus_gateway/config"
 )
 
 const (
@@ -17,20 +21,53 @@ var (
 	ErrInvalidProtocol = errors.New("Invalid Protocol")
 )
 
+type Server interface {
+	Start() error
+	Stop() error
+}
+
+func NewServer(cfg *config.Listener, timeout int, router *Router) Server {
+	switch cfg.Protocol {
+	case "rtu_over_tcp":
+		return NewRTUOverTCPServer(cfg, timeout, router)
+	case "serial":
+		return NewSerialServer(cfg, timeout, router)
+	case "tls":
+		return NewTLSServer(cfg, timeout, router)
+	}
+	return NewTCPServer(cfg, timeout, router)
+}
+
 type TCPServer struct {
 	running bool
+	name    string
 	listen  string
 	router  *Router
 	ln      net.Listener
 	timeout time.Duration
+	handler func(conn net.Conn)
+	conns   map[net.Conn]bool
+	lock    sync.Mutex
+	tlsConf *tls.Config
 }
 
-func NewTCPServer(listen string, timeout int, router *Router) *TCPServer {
-	return &TCPServer{
-		listen:  listen,
+func NewTCPServer(cfg *config.Listener, timeout int, router *Router) *TCPServer {
+	s := &TCPServer{
+		name:    cfg.Name,
+		listen:  cfg.Address,
 		router:  router,
-		timeout: time.Duration(timeout) * time.Second,
+		timeout: time.Duration(timeout) * time.Millisecond,
+		conns:   map[net.Conn]bool{},
 	}
+	s.handler = s.handleConn
+	return s
+}
+
+// Modbus/TCP Security server, MBAP over TLS
+func NewTLSServer(cfg *config.Listener, timeout int, router *Router) *TCPServer {
+	s := NewTCPServer(cfg, timeout, router)
+	s.tlsConf = cfg.TLSConfig()
+	return s
 }
 
 func (s *TCPServer) Start() error {
@@ -38,10 +75,14 @@ func (s *TCPServer) Start() error {
 	if err != nil {
 		return err
 	}
-	s.ln, err = net.ListenTCP("tcp", tcpAddr)
+	ln, err := net.ListenTCP("tcp", tcpAddr)
 	if err != nil {
 		return err
 	}
+	s.ln = ln
+	if s.tlsConf != nil {
+		s.ln = tls.NewListener(ln, s.tlsConf)
+	}
 	s.running = true
 	go s.runListen()
 	return nil
@@ -49,10 +90,17 @@ func (s *TCPServer) Start() error {
 
 func (s *TCPServer) Stop() error {
 	s.running = false
+	var err error
 	if s.ln != nil {
-		return s.ln.Close()
+		err = s.ln.Close()
 	}
-	return nil
+	// Close client connections
+	s.lock.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	return err
 }
 
 func (s *TCPServer) runListen() {
@@ -64,24 +112,58 @@ func (s *TCPServer) runListen() {
 			}
 			continue
 		}
-		go s.handleConn(conn)
+		go s.serveConn(conn)
 	}
 }
 
+func (s *TCPServer) serveConn(conn net.Conn) {
+	s.lock.Lock()
+	s.conns[conn] = true
+	s.lock.Unlock()
+	s.handler(conn)
+	s.lock.Lock()
+	delete(s.conns, conn)
+	s.lock.Unlock()
+}
+
+func (s *TCPServer) newClientInfo(conn net.Conn) (*clientInfo, error) {
+	client := &clientInfo{
+		listener:   s.name,
+		remoteAddr: conn.RemoteAddr().String(),
+	}
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return client, nil
+	}
+	err := client.handshake(tlsConn)
+	if err != nil {
+		return nil, err
+	}
+	if client.identity != "" {
+		log.Printf("Accept TLS client %s (role: %s) from %s", client.identity, client.role, client.remoteAddr)
+	}
+	return client, nil
+}
+
 func (s *TCPServer) handleConn(conn net.Conn) {
 	defer conn.Close()
+	client, err := s.newClientInfo(conn)
+	if err != nil {
+		log.Println("TLS handshake got error:", err)
+		return
+	}
 	for {
 		// Read the request
 		req, pdu, err := s.readRequest(conn)
 		if err != nil {
-			if !errors.Is(err, io.EOF) {
+			if !errors.Is(err, io.EOF) && s.running {
 				log.Println("Read request got error:", err)
 			}
 			break
 		}
 		// Route to backend
 		uid := pdu.unitID
-		resp, err := s.routeRequest(uid, pdu)
+		resp, err := s.routeRequest(client, uid, pdu)
 		if err != nil {
 			log.Println("Get response got error:", err)
 		}
@@ -163,6 +245,8 @@ func (s *TCPServer) writeResponse(conn net.Conn, req *mbap, resp *pdu) error {
 	return err
 }
 
-func (s *TCPServer) routeRequest(uid uint8, req *pdu) (*pdu, error) {
-	return s.router.RequestBackend(uid, req)
+func (s *TCPServer) routeRequest(client *clientInfo, uid uint8, req *pdu) (*pdu, error) {
+	ctx, cancel := newRequestContext(s.timeout)
+	defer cancel()
+	return s.router.RequestBackend(ctx, client, uid, req)
 }
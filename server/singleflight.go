@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+type flightCall struct {
+	done    chan struct{}
+	resp    *pdu
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Concurrent identical requests share one backend round trip
+type flightGroup struct {
+	calls map[string]*flightCall
+	lock  sync.Mutex
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		calls: map[string]*flightCall{},
+	}
+}
+
+// Key contains scheduling priority, target unit, function code and request
+// payload, so shared request is scheduled at priority of every caller
+func flightKey(prio int, req *pdu) string {
+	key := make([]byte, 0, len(req.payload)+3)
+	key = append(key, byte(prio), req.unitID, req.funcCode)
+	key = append(key, req.payload...)
+	return string(key)
+}
+
+// Execute fn once for concurrent calls with same key, every caller gets
+// a copy of the response. fn runs with a context detached from callers,
+// each caller returns context error only when its own context is done, and
+// fn is cancelled after all callers returned.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (*pdu, error)) (*pdu, error) {
+	g.lock.Lock()
+	call, have := g.calls[key]
+	if !have {
+		fctx, cancel := context.WithCancel(context.Background())
+		call = &flightCall{
+			done:   make(chan struct{}),
+			cancel: cancel,
+		}
+		g.calls[key] = call
+		go g.run(fctx, key, call, fn)
+	}
+	call.waiters++
+	g.lock.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		g.leave(key, call)
+		return nil, ctx.Err()
+	}
+	if call.resp == nil {
+		return nil, call.err
+	}
+	return copyPdu(call.resp), call.err
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, call *flightCall, fn func(ctx context.Context) (*pdu, error)) {
+	call.resp, call.err = fn(ctx)
+	g.remove(key, call)
+	call.cancel()
+	close(call.done)
+}
+
+// Caller gave up, cancel the call if no caller is waiting for it
+func (g *flightGroup) leave(key string, call *flightCall) {
+	g.lock.Lock()
+	call.waiters--
+	waiters := call.waiters
+	g.lock.Unlock()
+	if waiters == 0 {
+		g.remove(key, call)
+		call.cancel()
+	}
+}
+
+func (g *flightGroup) remove(key string, call *flightCall) {
+	g.lock.Lock()
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+	g.lock.Unlock()
+}
@@ -13,10 +13,6 @@ import (
 	"github.com/blacktear23/modbus_gateway/config"
 )
 
-var (
-	errNeedRetry = errors.New("Need retry")
-)
-
 type tcpTransport struct {
 	cfg     *config.Backend
 	conn    net.Conn
@@ -59,7 +55,7 @@ func (tt *tcpTransport) ensureConn() error {
 
 func (tt *tcpTransport) dial(addr *net.TCPAddr) (net.Conn, error) {
 	switch tt.cfg.Protocol {
-	case "tcp":
+	case "tcp", "rtu_over_tcp":
 		return tt.dialTcp(addr)
 	case "tls":
 		return tt.dialTls(addr)
@@ -75,9 +71,7 @@ func (tt *tcpTransport) dialTcp(addr *net.TCPAddr) (net.Conn, error) {
 }
 
 func (tt *tcpTransport) dialTls(addr *net.TCPAddr) (net.Conn, error) {
-	conf := &tls.Config{
-		InsecureSkipVerify: tt.cfg.TlsVerify,
-	}
+	conf := tt.cfg.TLSConfig().Clone()
 	dialer := &net.Dialer{}
 	if tt.timeout > 0 {
 		dialer.Deadline = time.Now().Add(tt.timeout)
@@ -109,33 +103,26 @@ func (tt *tcpTransport) ExecuteRequest(req *pdu) (*pdu, error) {
 		return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
 	}
 	resp, err := tt.executeRequestTCP(req)
-	if err != nil && err == errNeedRetry {
-		// Retry time
-		tt.cleanErrorConn()
-		log.Println("Rery connect backend", tt.cfg.Name)
-		if err := tt.ensureConn(); err != nil {
-			log.Println("Connect backend got error:", err)
-			return modbusErrorPdu(req, MErrGWTargetFailedToRespond), nil
-		}
-		rresp, err := tt.executeRequestTCP(req)
-		if err != nil {
-			return modbusErrorPdu(req, MErrGWPathUnavailable), err
-		}
-		return rresp, err
-	}
 	if err != nil {
+		if isConnectionClosed(err) {
+			// Reconnect when next request
+			tt.cleanErrorConn()
+		}
 		return modbusErrorPdu(req, MErrGWPathUnavailable), err
 	}
 	return resp, err
 }
 
-func isErrorNeedRetry(err error) bool {
+func isConnectionClosed(err error) bool {
 	if err == nil {
 		return false
 	}
 	if errors.Is(err, syscall.ECONNRESET) {
 		return true
 	}
+	if errors.Is(err, syscall.EPIPE) {
+		return true
+	}
 	if errors.Is(err, io.EOF) {
 		return true
 	}
@@ -155,14 +142,10 @@ func (tt *tcpTransport) executeRequestTCP(req *pdu) (*pdu, error) {
 	}
 	tt.lastTxn++
 	_, err = tt.conn.Write(tt.encodeMBAPFrame(tt.lastTxn, req))
-	if err != nil && isErrorNeedRetry(err) {
-		return nil, errNeedRetry
-	}
-	resp, err := tt.readResponse()
-	if err != nil && isErrorNeedRetry(err) {
-		return nil, errNeedRetry
+	if err != nil {
+		return nil, err
 	}
-	return resp, err
+	return tt.readResponse()
 }
 
 func (tt *tcpTransport) Close() error {
@@ -183,7 +166,7 @@ func (tt *tcpTransport) readResponse() (*pdu, error) {
 		err   error
 	)
 	for {
-		resp, vmbap, err = tt.readMBAPFrame()
+		resp, vmbap, err = tt.readMBAPFrame(tt.conn)
 		if err == ErrInvalidProtocol {
 			continue
 		}
@@ -201,9 +184,9 @@ func (tt *tcpTransport) readResponse() (*pdu, error) {
 	return resp, err
 }
 
-func (tt *tcpTransport) readMBAPFrame() (*pdu, *mbap, error) {
+func (tt *tcpTransport) readMBAPFrame(r io.Reader) (*pdu, *mbap, error) {
 	buf := make([]byte, mbapHeaderLen)
-	_, err := io.ReadFull(tt.conn, buf)
+	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -221,7 +204,7 @@ func (tt *tcpTransport) readMBAPFrame() (*pdu, *mbap, error) {
 	}
 
 	pduBuf := make([]byte, restBytes)
-	_, err = io.ReadFull(tt.conn, pduBuf)
+	_, err = io.ReadFull(r, pduBuf)
 	if err != nil {
 		return nil, nil, err
 	}
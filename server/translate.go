@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+// Read function code of data table
+var tableReadFunctions = map[string]uint8{
+	"coils":             FCReadCoils,
+	"discrete_inputs":   FCReadDiscreteInputs,
+	"holding_registers": FCReadHoldingRegisters,
+	"input_registers":   FCReadInputRegisters,
+}
+
+// Translate request address and function code by unit map translate rules,
+// response is translated back to the request.
+func (r *Router) requestTranslate(ctx context.Context, client *clientInfo, umap *config.UnitMap, req *pdu) (*pdu, error) {
+	treq, delta, code := translateRequest(umap.Translate, req)
+	if code != 0 {
+		return modbusErrorPdu(req, code), nil
+	}
+	resp, err := r.routeUnitMap(ctx, client, umap, treq)
+	if resp != nil {
+		translateResponse(req, resp, delta)
+	}
+	return resp, err
+}
+
+// Returns translated request and address delta of its first range, request
+// without data table access is returned as is. Exception code is returned
+// if request cannot be translated.
+func translateRequest(tr *config.Translate, req *pdu) (*pdu, int, uint8) {
+	ranges, err := requestRanges(req)
+	if err != nil {
+		return nil, 0, MErrIllegalDataValue
+	}
+	if len(ranges) == 0 {
+		return req, 0, 0
+	}
+	positions := addressPositions(req.funcCode)
+	treq := copyPdu(req)
+	deltas := make([]int, len(ranges))
+	targetTable := ""
+	for i, rng := range ranges {
+		table := tableNames[rng.table]
+		rule, err := tr.GetRule(table, rng.address, rng.count)
+		if err != nil {
+			return nil, 0, MErrIllegalDataAddress
+		}
+		delta, target := tr.Offset, table
+		if rule != nil {
+			delta, target = rule.TargetAddress-rule.Address, rule.TargetTable
+		}
+		if rng.address+delta < 0 || rng.address+delta+rng.count > 0x10000 {
+			return nil, 0, MErrIllegalDataAddress
+		}
+		// Read and write part of 0x17 should be in same table
+		if i > 0 && target != targetTable {
+			return nil, 0, MErrIllegalDataAddress
+		}
+		targetTable = target
+		deltas[i] = delta
+		translateAddress(treq.payload, positions[i:i+1], delta)
+	}
+	// Function code remap, such as input registers read from holding
+	// registers, target table of write should not be changed
+	if targetTable != tableNames[ranges[0].table] {
+		if !isReadFunction(req.funcCode) {
+			return nil, 0, MErrIllegalFunction
+		}
+		treq.funcCode = tableReadFunctions[targetTable]
+	}
+	return treq, deltas[0], 0
+}
+
+// Restore function code and address of response to translated request
+func translateResponse(req *pdu, resp *pdu, delta int) {
+	resp.funcCode = resp.funcCode&0x80 | req.funcCode
+	translateResponseAddress(resp, -delta)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/blacktear23/modbus_gateway/config"
+)
+
+func newTestTranslate(t *testing.T) *config.Translate {
+	tr := &config.Translate{
+		Offset: 100,
+		Rules: []*config.TranslateRule{
+			{AddressRange: config.AddressRange{Table: "holding_registers", Address: 0, Count: 10}, TargetTable: "input_registers", TargetAddress: 500},
+			{AddressRange: config.AddressRange{Table: "holding_registers", Address: 1000, Count: 10}, TargetAddress: 2000},
+			{AddressRange: config.AddressRange{Table: "coils", Address: 20, Count: 8}, TargetAddress: 0},
+		},
+	}
+	if err := tr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func TestTranslateRequest(t *testing.T) {
+	tr := newTestTranslate(t)
+	tests := []struct {
+		name string
+		req  *pdu
+		treq *pdu
+		code uint8
+	}{
+		{"remap table", &pdu{1, FCReadHoldingRegisters, []byte{0x00, 0x02, 0x00, 0x03}}, &pdu{1, FCReadInputRegisters, []byte{0x01, 0xf6, 0x00, 0x03}}, 0},
+		{"offset", &pdu{1, FCReadHoldingRegisters, []byte{0x00, 0x32, 0x00, 0x02}}, &pdu{1, FCReadHoldingRegisters, []byte{0x00, 0x96, 0x00, 0x02}}, 0},
+		{"offset bits", &pdu{1, FCReadDiscreteInputs, []byte{0x00, 0x00, 0x00, 0x10}}, &pdu{1, FCReadDiscreteInputs, []byte{0x00, 0x64, 0x00, 0x10}}, 0},
+		{"write register", &pdu{1, FCWriteSingleRegister, []byte{0x03, 0xed, 0x12, 0x34}}, &pdu{1, FCWriteSingleRegister, []byte{0x07, 0xd5, 0x12, 0x34}}, 0},
+		{"write registers", &pdu{1, FCWriteMultipleRegisters, []byte{0x03, 0xe8, 0x00, 0x01, 0x02, 0xbe, 0xef}}, &pdu{1, FCWriteMultipleRegisters, []byte{0x07, 0xd0, 0x00, 0x01, 0x02, 0xbe, 0xef}}, 0},
+		{"write coil", &pdu{1, FCWriteSingleCoil, []byte{0x00, 0x19, 0xff, 0x00}}, &pdu{1, FCWriteSingleCoil, []byte{0x00, 0x05, 0xff, 0x00}}, 0},
+		{"write coils", &pdu{1, FCWriteMultipleCoils, []byte{0x00, 0x14, 0x00, 0x08, 0x01, 0x5a}}, &pdu{1, FCWriteMultipleCoils, []byte{0x00, 0x00, 0x00, 0x08, 0x01, 0x5a}}, 0},
+		{"mask write", &pdu{1, FCMaskWriteRegister, []byte{0x00, 0x3c, 0x00, 0xf2, 0x00, 0x25}}, &pdu{1, FCMaskWriteRegister, []byte{0x00, 0xa0, 0x00, 0xf2, 0x00, 0x25}}, 0},
+		{"fifo", &pdu{1, FCReadFifoQueue, []byte{0x00, 0x46}}, &pdu{1, FCReadFifoQueue, []byte{0x00, 0xaa}}, 0},
+		{"read write", &pdu{1, FCReadWriteMultipleRegisters, []byte{0x03, 0xea, 0x00, 0x02, 0x00, 0x32, 0x00, 0x01, 0x02, 0x00, 0x01}}, &pdu{1, FCReadWriteMultipleRegisters, []byte{0x07, 0xd2, 0x00, 0x02, 0x00, 0x96, 0x00, 0x01, 0x02, 0x00, 0x01}}, 0},
+		{"no data table", &pdu{1, FCReportServerID, []byte{}}, &pdu{1, FCReportServerID, []byte{}}, 0},
+		{"read write tables", &pdu{1, FCReadWriteMultipleRegisters, []byte{0x00, 0x00, 0x00, 0x02, 0x00, 0x32, 0x00, 0x01, 0x02, 0x00, 0x01}}, nil, MErrIllegalDataAddress},
+		{"write remapped table", &pdu{1, FCWriteSingleRegister, []byte{0x00, 0x05, 0x00, 0x01}}, nil, MErrIllegalFunction},
+		{"cross rule", &pdu{1, FCReadHoldingRegisters, []byte{0x00, 0x08, 0x00, 0x04}}, nil, MErrIllegalDataAddress},
+		{"overflow", &pdu{1, FCReadHoldingRegisters, []byte{0xff, 0xdc, 0x00, 0x28}}, nil, MErrIllegalDataAddress},
+		{"short", &pdu{1, FCReadHoldingRegisters, []byte{0x00, 0x01}}, nil, MErrIllegalDataValue},
+	}
+	for _, tt := range tests {
+		orig := copyPdu(tt.req)
+		treq, delta, code := translateRequest(tr, tt.req)
+		if !equalPdu(tt.req, orig) {
+			t.Errorf("%s: request is changed to %+v", tt.name, tt.req)
+		}
+		if code != tt.code {
+			t.Errorf("%s: got exception 0x%02x, want 0x%02x", tt.name, code, tt.code)
+			continue
+		}
+		if code != 0 {
+			continue
+		}
+		if !equalPdu(treq, tt.treq) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, treq, tt.treq)
+			continue
+		}
+
+		// Exception response of device
+		resp := modbusErrorPdu(treq, MErrServerDeviceBusy)
+		translateResponse(tt.req, resp, delta)
+		if want := modbusErrorPdu(tt.req, MErrServerDeviceBusy); !equalPdu(resp, want) {
+			t.Errorf("%s: got exception response %+v, want %+v", tt.name, resp, want)
+		}
+		// Write response echoes request address, read response is data only
+		if isBroadcastFunction(tt.req.funcCode) {
+			resp = broadcastResponse(treq)
+			translateResponse(tt.req, resp, delta)
+			if want := broadcastResponse(tt.req); !equalPdu(resp, want) {
+				t.Errorf("%s: got response %+v, want %+v", tt.name, resp, want)
+			}
+			continue
+		}
+		data := []byte{0x02, 0xab, 0xcd}
+		resp = &pdu{treq.unitID, treq.funcCode, append([]byte(nil), data...)}
+		translateResponse(tt.req, resp, delta)
+		if resp.funcCode != tt.req.funcCode || !bytes.Equal(resp.payload, data) {
+			t.Errorf("%s: got response %+v, want function 0x%02x payload % x", tt.name, resp, tt.req.funcCode, data)
+		}
+	}
+}
@@ -8,15 +8,26 @@ import (
 
 var (
 	ErrBadCRC        = errors.New("Bad CRC")
+	ErrBadLRC        = errors.New("Bad LRC")
 	ErrShortFrame    = errors.New("Short frame")
 	ErrProtocolError = errors.New("Invalid protocol")
+	// Request length of function code is unknown
+	ErrIllegalFunction = errors.New("Illegal function")
 )
 
 func newTransport(cfg *config.Backend) Transport {
 	switch cfg.Protocol {
 	case "tcp", "tls":
+		if cfg.Pipeline {
+			return newPipelineTransport(cfg)
+		}
 		return newTcpTransport(cfg)
+	case "rtu_over_tcp":
+		return newRtuTcpTransport(cfg)
 	case "serial":
+		if cfg.Framing == "ascii" {
+			return newAsciiTransport(cfg)
+		}
 		return newSerialTransport(cfg)
 	}
 	return nil
@@ -42,3 +53,15 @@ func modbusErrorPdu(req *pdu, errCode uint8) *pdu {
 		payload:  []byte{errCode},
 	}
 }
+
+// Check response is gateway path unavailable or target failed to respond
+func isGatewayError(resp *pdu) bool {
+	if resp == nil || resp.funcCode&0x80 == 0 || len(resp.payload) == 0 {
+		return false
+	}
+	switch resp.payload[0] {
+	case MErrGWPathUnavailable, MErrGWTargetFailedToRespond:
+		return true
+	}
+	return false
+}